@@ -1,7 +1,10 @@
 package main
 
 import (
+	_ "mydocker/internal/nsenter"
+	"mydocker/pkg/cgroups"
 	"mydocker/pkg/command"
+	"mydocker/pkg/container"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -17,18 +20,36 @@ func main() {
 		command.InitCommand,
 		command.RunCommand,
 		command.ListCommand,
+		command.InspectCommand,
+		command.StatsCommand,
+		command.UpdateCommand,
 		command.CommitCommand,
 		command.LogCommand,
 		command.ExecCommand,
 		command.StopCommand,
+		command.PauseCommand,
+		command.UnpauseCommand,
 		command.RemoveCommand,
 		command.NetworkCommand,
 	}
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "cgroup-driver",
+			Value: "cgroupfs",
+			Usage: "cgroup driver, cgroupfs or systemd",
+		},
+	}
 	app.Before = func(c *cli.Context) error {
 		// 替换默认的ASCII格式化
 		log.SetFormatter(&log.JSONFormatter{})
 		log.SetOutput(os.Stdout)
-		return nil
+		// 普通用户运行时使用rootless模式
+		if os.Geteuid() != 0 {
+			if err := container.SetupRootless(); err != nil {
+				return err
+			}
+		}
+		return cgroups.SetDriver(c.GlobalString("cgroup-driver"))
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -3,8 +3,10 @@ module mydocker
 go 1.14
 
 require (
+	github.com/godbus/dbus/v5 v5.0.6
 	github.com/sirupsen/logrus v1.8.1
 	github.com/urfave/cli v1.22.5
 	github.com/vishvananda/netlink v1.1.0
 	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
+	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037
 )
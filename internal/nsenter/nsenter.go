@@ -1,58 +1,69 @@
 package nsenter
 
 /*
+#define _GNU_SOURCE
 #include <errno.h>
 #include <sched.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <unistd.h>
+#include <sys/types.h>
+#include <sys/wait.h>
 
 // 这里的__attribute__((constructor))指的是，一旦这个包被引用，那么这个函数就会被自动执行
-// 类似于构造函数，会在程序一启动的时候运行
+// 类似于构造函数，会在程序一启动的时候运行。setns 进入user和mount namespace要求进程是单线程的，
+// 所以必须在Go运行时启动之前完成
 __attribute__((constructor)) void enter_namespace(void) {
 	char *mydocker_pid;
 	// 从环境变量中获取需要进入的PID
 	mydocker_pid = getenv("mydocker_pid");
-	if (mydocker_pid) {
-		//fprintf(stdout, "got mydocker_pid=%s\n", mydocker_pid);
-	} else {
-		//fprintf(stdout, "missing mydocker_pid env skip nsenter");
-		// 这里如果没有指定PID，就不需要向下执行，直接退出
-		return;
-	}
-	char *mydocker_cmd;
-	// 从环境变量里面获取需要执行的命令
-	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		//fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		//fprintf(stdout, "missing mydocker_cmd env skip nsenter");
-	    // 这里如果没有指定命令，就不需要向下执行，直接退出
+	if (!mydocker_pid) {
+		// 这里如果没有指定PID，就不需要向下执行，直接返回
 		return;
 	}
 	int i;
 	char nspath[1024];
-	// 需要进入的五种Namespace
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
+	// 需要进入的六种Namespace，user namespace 需要最先进入，之后才有权限进入其他的Namespace
+	char *namespaces[] = { "user", "ipc", "uts", "net", "pid", "mnt" };
 
-	for (i=0; i<5; i++) {
+	for (i=0; i<6; i++) {
 		// 拼接对应的路径/proc/pid/ns/ipc，类似这样
-		sprintf(nspath, "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
+		snprintf(nspath, sizeof(nspath), "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
 		int fd = open(nspath, O_RDONLY);
+		if (fd == -1) {
+			fprintf(stderr, "open %s failed: %s\n", nspath, strerror(errno));
+			exit(1);
+		}
 		// 这里才真正调用setns系统调用进入对应的Namespace
-		if (setns(fd, 0) == -1) {
-			//fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			//fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
+		// 容器没有使用user namespace时，进入自己所在的user namespace会失败，直接忽略
+		if (setns(fd, 0) == -1 && i != 0) {
+			fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
+			exit(1);
 		}
 		close(fd);
 	}
-	// 在进入的Namespace中执行指定的命令
-	int res = system(mydocker_cmd);
-	// 退出
-	exit(0);
-	return;
+	// 进入pid namespace只对之后创建的子进程生效，由子进程继续执行Go代码，
+	// 当前进程等待子进程退出并返回它的退出码
+	pid_t child = fork();
+	if (child == -1) {
+		fprintf(stderr, "fork failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child == 0) {
+		return;
+	}
+	int status;
+	while (waitpid(child, &status, 0) == -1) {
+		if (errno != EINTR) {
+			exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
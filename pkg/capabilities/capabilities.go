@@ -0,0 +1,243 @@
+package capabilities
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// 所有capability的名字，下标就是capability的编号
+var capabilityNames = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// 容器默认保留的capabilities，与Docker的默认值一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// 统一为 CAP_XXX 的大写形式，--cap-add 和 --cap-drop 可以省略前缀
+func normalize(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "ALL" || strings.HasPrefix(name, "CAP_") {
+		return name
+	}
+	return "CAP_" + name
+}
+
+func capabilityValue(name string) (int, error) {
+	for i, n := range capabilityNames {
+		if n == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown capability %s", name)
+}
+
+// 当前内核支持的最大capability编号，读取失败时使用已知的最后一个
+func lastCap() int {
+	last := len(capabilityNames) - 1
+	content, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return last
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil && n < last {
+		return n
+	}
+	return last
+}
+
+// 当前内核支持的所有capabilities，用于 --privileged
+func All() []string {
+	return append([]string(nil), capabilityNames[:lastCap()+1]...)
+}
+
+// 在默认集合的基础上增加和去掉指定的capabilities，都可以使用 ALL。
+// 与Docker一样，同时出现在两边时以增加为准
+func Merge(base, add, drop []string) ([]string, error) {
+	addSet := map[string]bool{}
+	for _, name := range add {
+		name = normalize(name)
+		if name != "ALL" {
+			if _, err := capabilityValue(name); err != nil {
+				return nil, err
+			}
+		}
+		addSet[name] = true
+	}
+	dropSet := map[string]bool{}
+	for _, name := range drop {
+		name = normalize(name)
+		if name != "ALL" {
+			if _, err := capabilityValue(name); err != nil {
+				return nil, err
+			}
+		}
+		dropSet[name] = true
+	}
+	if addSet["ALL"] {
+		base = All()
+	} else if dropSet["ALL"] {
+		base = nil
+	}
+
+	caps := []string{}
+	seen := map[string]bool{}
+	for _, name := range base {
+		name = normalize(name)
+		if dropSet[name] && !addSet[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		caps = append(caps, name)
+	}
+	for _, name := range add {
+		name = normalize(name)
+		if name == "ALL" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		caps = append(caps, name)
+	}
+	return caps, nil
+}
+
+// 把不在 caps 中的capability从bounding集合中去掉，之后即使执行setuid程序也无法再获得。
+// capability 是线程的属性，调用者需要锁定线程并在同一线程上执行用户程序
+func DropBounding(caps []string) error {
+	keep, err := capabilitySet(caps)
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= lastCap(); i++ {
+		if keep[i] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(i), 0, 0, 0); err != nil {
+			return fmt.Errorf("drop bounding capability %s error %v", capabilityNames[i], err)
+		}
+	}
+	return nil
+}
+
+// 切换用户前调用，使 setuid 到非root用户之后仍保留permitted集合
+func KeepCaps() error {
+	return unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0)
+}
+
+// 把effective、permitted、inheritable集合设置为 caps，并加入ambient集合，
+// 这样非root用户执行的程序也能拥有这些capabilities
+func Apply(caps []string) error {
+	keep, err := capabilitySet(caps)
+	if err != nil {
+		return err
+	}
+	header := capHeader{version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]capData
+	for i := range keep {
+		data[i/32].effective |= 1 << uint(i%32)
+	}
+	for i := range data {
+		data[i].permitted = data[i].effective
+		data[i].inheritable = data[i].effective
+	}
+	if _, _, errno := unix.RawSyscall(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset error %v", errno)
+	}
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear keep caps error %v", err)
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear ambient capabilities error %v", err)
+	}
+	for i := range keep {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(i), 0, 0); err != nil {
+			return fmt.Errorf("raise ambient capability %s error %v", capabilityNames[i], err)
+		}
+	}
+	return nil
+}
+
+// 把名字转换成编号的集合，忽略当前内核不支持的capability
+func capabilitySet(caps []string) (map[int]bool, error) {
+	set := map[int]bool{}
+	last := lastCap()
+	for _, name := range caps {
+		value, err := capabilityValue(normalize(name))
+		if err != nil {
+			return nil, err
+		}
+		if value <= last {
+			set[value] = true
+		}
+	}
+	return set, nil
+}
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
@@ -0,0 +1,39 @@
+package capabilities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := []string{"CAP_CHOWN", "CAP_KILL"}
+	tests := []struct {
+		add, drop []string
+		want      []string
+	}{
+		{nil, nil, []string{"CAP_CHOWN", "CAP_KILL"}},
+		{[]string{"net_admin"}, []string{"chown"}, []string{"CAP_KILL", "CAP_NET_ADMIN"}},
+		{[]string{"CAP_SYS_ADMIN"}, []string{"ALL"}, []string{"CAP_SYS_ADMIN"}},
+		{[]string{"kill"}, []string{"kill"}, []string{"CAP_CHOWN", "CAP_KILL"}},
+	}
+	for _, tt := range tests {
+		got, err := Merge(base, tt.add, tt.drop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Merge(%v, %v) = %v, want %v", tt.add, tt.drop, got, tt.want)
+		}
+	}
+
+	all, err := Merge(base, []string{"all"}, []string{"chown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(All())-1 {
+		t.Errorf("add ALL drop CHOWN got %d capabilities, want %d", len(all), len(All())-1)
+	}
+	if _, err := Merge(base, []string{"NOT_A_CAP"}, nil); err == nil {
+		t.Error("expected error for unknown capability")
+	}
+}
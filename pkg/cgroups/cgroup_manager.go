@@ -1,45 +1,97 @@
 package cgroups
 
 import (
+	"fmt"
 	"mydocker/pkg/cgroups/subsystems"
+)
+
+// cgroup驱动，负责创建cgroup、设置资源限制、把进程加入cgroup以及删除cgroup
+type Driver interface {
+	Name() string
+	// 根据父cgroup和容器ID得到容器cgroup相对于hierarchy根节点的路径
+	CgroupPath(parent, id string) (string, error)
+	// 把进程加入cgroup，res 为此前通过 Set 设置的资源限制
+	Apply(path string, pid int, res *subsystems.ResourceConfig) error
+	Set(path string, res *subsystems.ResourceConfig) error
+	Remove(path string) error
+}
 
-	log "github.com/sirupsen/logrus"
+var (
+	drivers = map[string]Driver{
+		"cgroupfs": &FsDriver{},
+		"systemd":  &SystemdDriver{},
+	}
+	// 通过全局参数 --cgroup-driver 选择，默认直接读写cgroup文件系统
+	defaultDriver Driver = drivers["cgroupfs"]
 )
 
+// 设置默认的cgroup驱动
+func SetDriver(name string) error {
+	driver, err := GetDriver(name)
+	if err != nil {
+		return err
+	}
+	defaultDriver = driver
+	return nil
+}
+
+func GetDriver(name string) (Driver, error) {
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cgroup driver %s", name)
+	}
+	return driver, nil
+}
+
+func DefaultDriverName() string {
+	return defaultDriver.Name()
+}
+
+// 使用默认驱动计算容器cgroup的路径
+func CgroupPath(parent, id string) (string, error) {
+	return defaultDriver.CgroupPath(parent, id)
+}
+
 type CgroupManager struct {
 	Path     string
 	Resource *subsystems.ResourceConfig
+	Driver   Driver
 }
 
 func NewCgroupManager(path string) *CgroupManager {
 	return &CgroupManager{
-		Path: path,
+		Path:   path,
+		Driver: defaultDriver,
 	}
 }
 
 func (c *CgroupManager) ApplyAll(pid int) error {
-	for _, subSysIns := range subsystems.SubsystemIns {
-		if err := subSysIns.Apply(c.Path, pid); err != nil {
-			log.Warnf("apply cgroup fail %v", err)
-		}
-	}
-	return nil
+	return c.Driver.Apply(c.Path, pid, c.Resource)
 }
 
+// 设置各subsystem的资源限制，某个subsystem失败时仍会继续设置其他的，并返回最后一个错误
 func (c *CgroupManager) SetAll(res *subsystems.ResourceConfig) error {
-	for _, subSysIns := range subsystems.SubsystemIns {
-		if err := subSysIns.Set(c.Path, res); err != nil {
-			log.Warnf("set cgroup fail %v", err)
-		}
-	}
-	return nil
+	c.Resource = res
+	return c.Driver.Set(c.Path, res)
 }
 
 func (c *CgroupManager) RemoveAll() error {
-	for _, subSysIns := range subsystems.SubsystemIns {
-		if err := subSysIns.Remove(c.Path); err != nil {
-			log.Warnf("remove cgroup fail %v", err)
-		}
-	}
-	return nil
+	return c.Driver.Remove(c.Path)
+}
+
+// 读取cgroup中各subsystem的资源使用情况
+func (c *CgroupManager) GetStats() *subsystems.Stats {
+	return (&FsDriver{}).GetStats(c.Path)
+}
+
+// 冻结或者解冻cgroup中的所有进程，state 为 subsystems.Frozen 或 subsystems.Thawed
+func (c *CgroupManager) Freeze(state string) error {
+	freezer := &subsystems.FreezerSubsystem{}
+	return freezer.SetState(c.Path, state)
+}
+
+// 监听cgroup中的OOM事件
+func (c *CgroupManager) NotifyOOM() (<-chan struct{}, error) {
+	memory := &subsystems.MemorySubsystem{}
+	return memory.NotifyOOM(c.Path)
 }
@@ -0,0 +1,305 @@
+// cgroup v2 没有 devices 控制器，设备访问控制通过挂载到cgroup上的 BPF_PROG_TYPE_CGROUP_DEVICE 程序实现
+package devicefilter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"mydocker/pkg/devices"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpf 系统调用的命令以及相关常量，见 linux/bpf.h
+const (
+	bpfProgLoad      = 5
+	bpfProgAttach    = 8
+	bpfProgDetach    = 9
+	bpfProgGetFdById = 13
+	bpfProgQuery     = 16
+
+	bpfProgTypeCgroupDevice = 15
+	bpfCgroupDevice         = 6
+	bpfFAllowMulti          = 2
+
+	// bpf_cgroup_dev_ctx 中的设备类型和访问类型
+	devTypeBlock = 1
+	devTypeChar  = 2
+	accessMknod  = 1
+	accessRead   = 2
+	accessWrite  = 4
+)
+
+// eBPF 指令的操作码
+const (
+	opLdxMemW  = 0x61 // dst = *(u32 *)(src + off)
+	opAndImm   = 0x54 // dst &= imm (32位)
+	opRshImm   = 0x74 // dst >>= imm (32位)
+	opMovReg   = 0xbf // dst = src
+	opMovImm   = 0xb7 // dst = imm
+	opJneImm   = 0x55 // if dst != imm goto pc + off
+	opJneReg   = 0x5d // if dst != src goto pc + off
+	opExit     = 0x95
+	insnLength = 8
+)
+
+type insn struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+}
+
+// 生成设备过滤程序，rules 为白名单，命中任意一条规则即允许访问，否则拒绝
+//
+// 程序的参数是 struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }，
+// 其中 access_type 的低16位为设备类型，高16位为访问类型
+func DeviceFilter(rules []*devices.Device) ([]byte, error) {
+	prog := []insn{
+		// r2 = 设备类型
+		{code: opLdxMemW, dst: 2, src: 1, off: 0},
+		{code: opAndImm, dst: 2, imm: 0xFFFF},
+		// r3 = 访问类型
+		{code: opLdxMemW, dst: 3, src: 1, off: 0},
+		{code: opRshImm, dst: 3, imm: 16},
+		// r4 = major, r5 = minor
+		{code: opLdxMemW, dst: 4, src: 1, off: 4},
+		{code: opLdxMemW, dst: 5, src: 1, off: 8},
+	}
+	for _, rule := range rules {
+		block, err := ruleBlock(rule)
+		if err != nil {
+			return nil, err
+		}
+		prog = append(prog, block...)
+	}
+	// 没有匹配的规则，拒绝访问
+	prog = append(prog,
+		insn{code: opMovImm, dst: 0, imm: 0},
+		insn{code: opExit},
+	)
+	return encode(prog), nil
+}
+
+// 每条规则生成一段指令，任意一个条件不满足就跳到下一条规则，全部满足则返回1表示允许
+func ruleBlock(rule *devices.Device) ([]insn, error) {
+	var checks [][]insn
+	switch rule.Type {
+	case devices.AllDevices:
+	case devices.CharDevice:
+		checks = append(checks, []insn{{code: opJneImm, dst: 2, imm: devTypeChar}})
+	case devices.BlockDevice:
+		checks = append(checks, []insn{{code: opJneImm, dst: 2, imm: devTypeBlock}})
+	default:
+		return nil, fmt.Errorf("invalid device type %c", rule.Type)
+	}
+
+	var access int32
+	for _, c := range rule.Permissions {
+		switch c {
+		case 'r':
+			access |= accessRead
+		case 'w':
+			access |= accessWrite
+		case 'm':
+			access |= accessMknod
+		default:
+			return nil, fmt.Errorf("invalid device permissions %s", rule.Permissions)
+		}
+	}
+	if access != accessRead|accessWrite|accessMknod {
+		// 请求的访问类型必须都在允许的范围内：(r3 & access) == r3
+		checks = append(checks, []insn{
+			{code: opMovReg, dst: 1, src: 3},
+			{code: opAndImm, dst: 1, imm: access},
+			{code: opJneReg, dst: 1, src: 3},
+		})
+	}
+	if rule.Major != devices.Wildcard {
+		checks = append(checks, []insn{{code: opJneImm, dst: 4, imm: int32(rule.Major)}})
+	}
+	if rule.Minor != devices.Wildcard {
+		checks = append(checks, []insn{{code: opJneImm, dst: 5, imm: int32(rule.Minor)}})
+	}
+
+	var block []insn
+	for _, check := range checks {
+		block = append(block, check...)
+	}
+	block = append(block,
+		insn{code: opMovImm, dst: 0, imm: 1},
+		insn{code: opExit},
+	)
+	// 条件跳转都跳到本段指令的末尾，即下一条规则的开头
+	for i := range block {
+		if block[i].code == opJneImm || block[i].code == opJneReg {
+			block[i].off = int16(len(block) - i - 1)
+		}
+	}
+	return block, nil
+}
+
+func encode(prog []insn) []byte {
+	buf := make([]byte, len(prog)*insnLength)
+	for i, ins := range prog {
+		b := buf[i*insnLength:]
+		b[0] = ins.code
+		b[1] = ins.src<<4 | ins.dst&0x0f
+		binary.LittleEndian.PutUint16(b[2:], uint16(ins.off))
+		binary.LittleEndian.PutUint32(b[4:], uint32(ins.imm))
+	}
+	return buf
+}
+
+// 加载设备过滤程序并挂载到cgroup上，然后卸载之前挂载的程序，实现替换
+func LoadAttach(cgroupDirFd int, prog []byte) error {
+	oldProgs, err := queryPrograms(cgroupDirFd)
+	if err != nil {
+		return err
+	}
+	progFd, err := loadProgram(prog)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(progFd)
+
+	attr := struct {
+		targetFd     uint32
+		attachBpfFd  uint32
+		attachType   uint32
+		attachFlags  uint32
+		replaceBpfFd uint32
+	}{
+		targetFd:    uint32(cgroupDirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  bpfCgroupDevice,
+		attachFlags: bpfFAllowMulti,
+	}
+	if _, err := bpf(bpfProgAttach, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("attach device filter error %v", err)
+	}
+	for _, id := range oldProgs {
+		if err := detachProgram(cgroupDirFd, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadProgram(prog []byte) (int, error) {
+	fd, err := bpfProgLoadWithLog(prog, nil)
+	if err != nil {
+		// 加载失败时带上verifier日志重试一次，便于定位问题
+		logBuf := make([]byte, 64*1024)
+		retryFd, retryErr := bpfProgLoadWithLog(prog, logBuf)
+		if retryErr == nil {
+			syscall.Close(retryFd)
+		}
+		return -1, fmt.Errorf("load device filter error %v: %s", err, cString(logBuf))
+	}
+	return fd, nil
+}
+
+func bpfProgLoadWithLog(prog []byte, logBuf []byte) (int, error) {
+	license := []byte("GPL\x00")
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: bpfProgTypeCgroupDevice,
+		insnCnt:  uint32(len(prog) / insnLength),
+		insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	if len(logBuf) > 0 {
+		attr.logLevel = 1
+		attr.logSize = uint32(len(logBuf))
+		attr.logBuf = uint64(uintptr(unsafe.Pointer(&logBuf[0])))
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(logBuf)
+	if err != nil {
+		return -1, err
+	}
+	return int(fd), nil
+}
+
+// 查询cgroup上已经挂载的设备过滤程序
+func queryPrograms(cgroupDirFd int) ([]uint32, error) {
+	ids := make([]uint32, 64)
+	attr := struct {
+		targetFd    uint32
+		attachType  uint32
+		queryFlags  uint32
+		attachFlags uint32
+		progIds     uint64
+		progCnt     uint32
+	}{
+		targetFd:   uint32(cgroupDirFd),
+		attachType: bpfCgroupDevice,
+		progIds:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+		progCnt:    uint32(len(ids)),
+	}
+	_, err := bpf(bpfProgQuery, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(ids)
+	if err != nil {
+		return nil, fmt.Errorf("query device filters error %v", err)
+	}
+	return ids[:attr.progCnt], nil
+}
+
+func detachProgram(cgroupDirFd int, id uint32) error {
+	getFdAttr := struct {
+		progId    uint32
+		nextId    uint32
+		openFlags uint32
+	}{progId: id}
+	progFd, err := bpf(bpfProgGetFdById, unsafe.Pointer(&getFdAttr), unsafe.Sizeof(getFdAttr))
+	if err != nil {
+		return fmt.Errorf("get device filter %d error %v", id, err)
+	}
+	defer syscall.Close(int(progFd))
+
+	attr := struct {
+		targetFd    uint32
+		attachBpfFd uint32
+		attachType  uint32
+	}{
+		targetFd:    uint32(cgroupDirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  bpfCgroupDevice,
+	}
+	if _, err := bpf(bpfProgDetach, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("detach device filter %d error %v", id, err)
+	}
+	return nil
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
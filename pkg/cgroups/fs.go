@@ -0,0 +1,63 @@
+package cgroups
+
+import (
+	"mydocker/pkg/cgroups/subsystems"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 直接读写cgroup文件系统的驱动
+type FsDriver struct {
+}
+
+func (d *FsDriver) Name() string {
+	return "cgroupfs"
+}
+
+func (d *FsDriver) CgroupPath(parent, id string) (string, error) {
+	return path.Join(parent, id), nil
+}
+
+func (d *FsDriver) Apply(cgroupPath string, pid int, res *subsystems.ResourceConfig) error {
+	var lastErr error
+	for _, subSysIns := range subsystems.SubsystemIns {
+		if err := subSysIns.Apply(cgroupPath, pid); err != nil {
+			log.Warnf("apply cgroup fail %v", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (d *FsDriver) Set(cgroupPath string, res *subsystems.ResourceConfig) error {
+	var lastErr error
+	for _, subSysIns := range subsystems.SubsystemIns {
+		if err := subSysIns.Set(cgroupPath, res); err != nil {
+			log.Warnf("set cgroup fail %v", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (d *FsDriver) Remove(cgroupPath string) error {
+	for _, subSysIns := range subsystems.SubsystemIns {
+		if err := subSysIns.Remove(cgroupPath); err != nil {
+			log.Warnf("remove cgroup fail %v", err)
+		}
+	}
+	return nil
+}
+
+func (d *FsDriver) GetStats(cgroupPath string) *subsystems.Stats {
+	stats := &subsystems.Stats{}
+	for _, subSysIns := range subsystems.SubsystemIns {
+		if statsIns, ok := subSysIns.(subsystems.StatsSubsystem); ok {
+			if err := statsIns.GetStats(cgroupPath, stats); err != nil {
+				log.Warnf("get %s stats fail %v", subSysIns.Name(), err)
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,119 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mydocker/pkg/devices"
+	"path"
+	"strconv"
+)
+
+// 针对某个块设备的IO限速配置，设备通过 major:minor 标识
+type ThrottleDevice struct {
+	Path  string `json:"path"`
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+// 根据设备路径解析出块设备的 major:minor
+func NewThrottleDevice(devicePath string, rate uint64) (*ThrottleDevice, error) {
+	d, err := devices.DeviceFromPath(devicePath, "")
+	if err != nil {
+		return nil, err
+	}
+	if d.Type != devices.BlockDevice {
+		return nil, fmt.Errorf("%s is not a block device", devicePath)
+	}
+	return &ThrottleDevice{
+		Path:  devicePath,
+		Major: d.Major,
+		Minor: d.Minor,
+		Rate:  rate,
+	}, nil
+}
+
+func (td *ThrottleDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", td.Major, td.Minor, td.Rate)
+}
+
+type BlkioSubsystem struct {
+}
+
+func (s *BlkioSubsystem) Name() string {
+	return "blkio"
+}
+
+func (s *BlkioSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if IsCgroup2UnifiedMode() {
+			return setIoMax(subsysCgroupPath, res)
+		}
+		if res.BlkioWeight != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.weight"),
+				[]byte(strconv.FormatUint(uint64(res.BlkioWeight), 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup blkio weight fail %v", err)
+			}
+		}
+		throttles := map[string][]*ThrottleDevice{
+			"blkio.throttle.read_bps_device":   res.BlkioDeviceReadBps,
+			"blkio.throttle.write_bps_device":  res.BlkioDeviceWriteBps,
+			"blkio.throttle.read_iops_device":  res.BlkioDeviceReadIOps,
+			"blkio.throttle.write_iops_device": res.BlkioDeviceWriteIOps,
+		}
+		for file, devices := range throttles {
+			// 每个设备需要单独写入一行
+			for _, td := range devices {
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(td.String()), 0644); err != nil {
+					return fmt.Errorf("set cgroup %s fail %v", file, err)
+				}
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubsystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return applyPid(subsysCgroupPath, pid)
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *BlkioSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// v2 使用 io 控制器，权重写入 io.weight，限速写入 io.max，格式为 "major:minor rbps=X wbps=X riops=X wiops=X"
+func setIoMax(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.BlkioWeight != 0 {
+		weight := ConvertBlkioToIoWeight(res.BlkioWeight)
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.weight"),
+			[]byte("default "+strconv.FormatUint(weight, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup io weight fail %v", err)
+		}
+	}
+	throttles := map[string][]*ThrottleDevice{
+		"rbps":  res.BlkioDeviceReadBps,
+		"wbps":  res.BlkioDeviceWriteBps,
+		"riops": res.BlkioDeviceReadIOps,
+		"wiops": res.BlkioDeviceWriteIOps,
+	}
+	for key, devices := range throttles {
+		for _, td := range devices {
+			value := fmt.Sprintf("%d:%d %s=%d", td.Major, td.Minor, key, td.Rate)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.max"), []byte(value), 0644); err != nil {
+				return fmt.Errorf("set cgroup io max fail %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// 把 v1 的 blkio.weight [10-1000] 线性映射到 v2 的 io.weight [1-10000]
+func ConvertBlkioToIoWeight(blkioWeight uint16) uint64 {
+	return 1 + (uint64(blkioWeight)-10)*9999/990
+}
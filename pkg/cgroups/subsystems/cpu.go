@@ -3,7 +3,6 @@ package subsystems
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
 	"strconv"
 )
@@ -18,10 +17,25 @@ func (s *CpuSubSystem) Name() string {
 func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
 		if res.CpuShare != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
+			shareFile, value := "cpu.shares", res.CpuShare
+			// v2 没有 cpu.shares，需要把 shares 换算成 cpu.weight
+			if IsCgroup2UnifiedMode() {
+				shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid cpu share %s: %v", res.CpuShare, err)
+				}
+				shareFile, value = "cpu.weight", strconv.FormatUint(ConvertSharesToWeight(shares), 10)
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, shareFile), []byte(value), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpu share fail %v", err)
 			}
 		}
+		if res.CpuQuota != 0 || res.CpuPeriod != 0 {
+			if IsCgroup2UnifiedMode() {
+				return setCpuMax(subsysCgroupPath, res)
+			}
+			return setCfsQuota(subsysCgroupPath, res)
+		}
 		return nil
 	} else {
 		return err
@@ -30,19 +44,55 @@ func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (s *CpuSubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
-			return fmt.Errorf("set cgroup proc fail %v", err)
-		}
-		return nil
+		return applyPid(subsysCgroupPath, pid)
 	} else {
 		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
 	}
 }
 
 func (s *CpuSubSystem) Remove(cgroupPath string) error {
-	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		return os.RemoveAll(subsysCgroupPath)
-	} else {
-		return err
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// v1 分别写入 cpu.cfs_period_us 和 cpu.cfs_quota_us，先写周期，避免 quota 和旧的周期冲突
+func setCfsQuota(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.CpuPeriod != 0 {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"),
+			[]byte(strconv.FormatUint(res.CpuPeriod, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu period fail %v", err)
+		}
+	}
+	if res.CpuQuota != 0 {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"),
+			[]byte(strconv.FormatInt(res.CpuQuota, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu quota fail %v", err)
+		}
+	}
+	return nil
+}
+
+// v2 把 quota 和周期一起写入 cpu.max，格式为 "$MAX $PERIOD"，不限制时 $MAX 为 max
+func setCpuMax(subsysCgroupPath string, res *ResourceConfig) error {
+	quota := "max"
+	if res.CpuQuota > 0 {
+		quota = strconv.FormatInt(res.CpuQuota, 10)
+	}
+	value := quota
+	if res.CpuPeriod != 0 {
+		value = quota + " " + strconv.FormatUint(res.CpuPeriod, 10)
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(value), 0644); err != nil {
+		return fmt.Errorf("set cgroup cpu max fail %v", err)
+	}
+	return nil
+}
+
+// 把 v1 的 cpu.shares [2-262144] 线性映射到 v2 的 cpu.weight [1-10000]
+func ConvertSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	} else if shares > 262144 {
+		shares = 262144
 	}
+	return 1 + ((shares-2)*9999)/262142
 }
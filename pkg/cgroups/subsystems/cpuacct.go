@@ -0,0 +1,49 @@
+package subsystems
+
+import (
+	"fmt"
+	"path"
+)
+
+// cpuacct 只负责统计CPU用量，部分系统中它和 cpu 没有挂载在同一个hierarchy上
+type CpuacctSubsystem struct {
+}
+
+func (s *CpuacctSubsystem) Name() string {
+	return "cpuacct"
+}
+
+func (s *CpuacctSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *CpuacctSubsystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return applyPid(subsysCgroupPath, pid)
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *CpuacctSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// v1 读取 cpuacct.usage，v2 中CPU用量记录在 cpu.stat 的 usage_usec
+func (s *CpuacctSubsystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2UnifiedMode() {
+		values, err := readKeyValues(path.Join(subsysCgroupPath, "cpu.stat"))
+		if err != nil {
+			return err
+		}
+		stats.CpuUsage = values["usage_usec"] * 1000
+		return nil
+	}
+	stats.CpuUsage, err = readUint(path.Join(subsysCgroupPath, "cpuacct.usage"))
+	return err
+}
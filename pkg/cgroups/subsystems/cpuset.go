@@ -3,9 +3,7 @@ package subsystems
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
-	"strconv"
 )
 
 type CpusetSubsystem struct {
@@ -30,19 +28,12 @@ func (s *CpusetSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (s *CpusetSubsystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
-			return fmt.Errorf("set cgroup proc fail %v", err)
-		}
-		return nil
+		return applyPid(subsysCgroupPath, pid)
 	} else {
 		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
 	}
 }
 
 func (s *CpusetSubsystem) Remove(cgroupPath string) error {
-	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		return os.RemoveAll(subsysCgroupPath)
-	} else {
-		return err
-	}
+	return removeCgroup(s.Name(), cgroupPath)
 }
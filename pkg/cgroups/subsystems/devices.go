@@ -0,0 +1,66 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mydocker/pkg/cgroups/devicefilter"
+	"path"
+	"syscall"
+)
+
+type DevicesSubsystem struct {
+}
+
+func (s *DevicesSubsystem) Name() string {
+	return "devices"
+}
+
+func (s *DevicesSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	// 没有设置设备规则时保持继承父cgroup的设备权限
+	if res.Devices == nil {
+		return nil
+	}
+	if IsCgroup2UnifiedMode() {
+		return setDeviceFilter(subsysCgroupPath, res)
+	}
+	// v1 先拒绝所有设备，再逐条加入白名单
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("set cgroup devices deny fail %v", err)
+	}
+	for _, d := range res.Devices {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(d.CgroupString()), 0644); err != nil {
+			return fmt.Errorf("set cgroup devices allow %s fail %v", d.CgroupString(), err)
+		}
+	}
+	return nil
+}
+
+// v2 通过在cgroup目录上挂载eBPF程序来控制设备访问
+func setDeviceFilter(subsysCgroupPath string, res *ResourceConfig) error {
+	prog, err := devicefilter.DeviceFilter(res.Devices)
+	if err != nil {
+		return err
+	}
+	fd, err := syscall.Open(subsysCgroupPath, syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open cgroup %s error %v", subsysCgroupPath, err)
+	}
+	defer syscall.Close(fd)
+	return devicefilter.LoadAttach(fd, prog)
+}
+
+func (s *DevicesSubsystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return applyPid(subsysCgroupPath, pid)
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *DevicesSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
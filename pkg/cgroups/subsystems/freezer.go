@@ -0,0 +1,76 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	Frozen = "FROZEN"
+	Thawed = "THAWED"
+)
+
+// freezer 不限制资源，而是用来挂起和恢复cgroup中的所有进程
+type FreezerSubsystem struct {
+}
+
+func (s *FreezerSubsystem) Name() string {
+	return "freezer"
+}
+
+func (s *FreezerSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *FreezerSubsystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return applyPid(subsysCgroupPath, pid)
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *FreezerSubsystem) Remove(cgroupPath string) error {
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// 冻结或者解冻cgroup中的进程，并等待状态切换完成
+// v1 写入 freezer.state，v2 写入 cgroup.freeze 并通过 cgroup.events 中的 frozen 字段确认
+func (s *FreezerSubsystem) SetState(cgroupPath string, state string) error {
+	if state != Frozen && state != Thawed {
+		return fmt.Errorf("invalid freezer state %s", state)
+	}
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	stateFile, value, eventsFile, expected := "freezer.state", state, "freezer.state", state
+	if IsCgroup2UnifiedMode() {
+		stateFile, value, eventsFile, expected = "cgroup.freeze", "0", "cgroup.events", "frozen 0"
+		if state == Frozen {
+			value, expected = "1", "frozen 1"
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, stateFile), []byte(value), 0644); err != nil {
+		return fmt.Errorf("set cgroup freezer state fail %v", err)
+	}
+	// 冻结是异步完成的，v1 中会先经过 FREEZING 状态
+	for i := 0; i < 1000; i++ {
+		content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, eventsFile))
+		if err != nil {
+			return fmt.Errorf("read cgroup freezer state fail %v", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.TrimSpace(line) == expected {
+				return nil
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("wait for cgroup freezer state %s timeout", state)
+}
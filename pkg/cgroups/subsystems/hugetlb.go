@@ -0,0 +1,105 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 内核支持的大页信息所在目录，每种大页对应一个 hugepages-<size>kB 子目录
+const hugepagesDir = "/sys/kernel/mm/hugepages"
+
+var (
+	hugePageSizesOnce sync.Once
+	hugePageSizes     []string
+)
+
+// 某一种大页的用量限制，PageSize 为cgroup接口文件中使用的名称，例如 2MB、1GB
+type HugepageLimit struct {
+	PageSize string `json:"pageSize"`
+	Limit    uint64 `json:"limit"`
+}
+
+type HugetlbSubsystem struct {
+}
+
+func (s *HugetlbSubsystem) Name() string {
+	return "hugetlb"
+}
+
+func (s *HugetlbSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
+	// 很多内核没有启用hugetlb，没有设置限制时不要求它可用
+	if !subsystemAvailable(s.Name()) {
+		if len(res.HugetlbLimit) == 0 {
+			return nil
+		}
+		return fmt.Errorf("hugetlb is not enabled on this host")
+	}
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	for _, limit := range res.HugetlbLimit {
+		// v1 为 hugetlb.<size>.limit_in_bytes，v2 为 hugetlb.<size>.max
+		file := "hugetlb." + limit.PageSize + ".limit_in_bytes"
+		if IsCgroup2UnifiedMode() {
+			file = "hugetlb." + limit.PageSize + ".max"
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(strconv.FormatUint(limit.Limit, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup hugetlb %s fail %v", limit.PageSize, err)
+		}
+	}
+	return nil
+}
+
+func (s *HugetlbSubsystem) Apply(cgroupPath string, pid int) error {
+	if !subsystemAvailable(s.Name()) {
+		return nil
+	}
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return applyPid(subsysCgroupPath, pid)
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *HugetlbSubsystem) Remove(cgroupPath string) error {
+	if !subsystemAvailable(s.Name()) {
+		return nil
+	}
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// 返回内核支持的大页大小，例如 [2MB 1GB]，结果只需读取一次
+func HugePageSizes() []string {
+	hugePageSizesOnce.Do(func() {
+		entries, err := ioutil.ReadDir(hugepagesDir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			// 目录名形如 hugepages-2048kB
+			name := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "hugepages-"), "kB")
+			kb, err := strconv.ParseUint(name, 10, 64)
+			if err != nil {
+				continue
+			}
+			hugePageSizes = append(hugePageSizes, HugePageSizeName(kb*1024))
+		}
+	})
+	return hugePageSizes
+}
+
+// 把大页的字节数转换成cgroup接口文件中的名称，例如 2097152 => 2MB
+func HugePageSizeName(size uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for size >= 1024 && size%1024 == 0 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return strconv.FormatUint(size, 10) + units[i]
+}
@@ -0,0 +1,42 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// 模拟没有hugetlb控制器的cgroup v2
+func TestHugetlbControllerAbsent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	isUnifiedOnce.Do(func() {})
+	origMountpoint, origUnified := unifiedMountpoint, isUnified
+	unifiedMountpoint, isUnified = dir, true
+	defer func() { unifiedMountpoint, isUnified = origMountpoint, origUnified }()
+
+	s := &HugetlbSubsystem{}
+	if err := s.Set("mydocker/test", &ResourceConfig{}); err != nil {
+		t.Fatalf("set without limits: %v", err)
+	}
+	if err := s.Apply("mydocker/test", 1); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if err := s.Remove("mydocker/test"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, "mydocker")); !os.IsNotExist(err) {
+		t.Fatalf("cgroup should not be created, stat error %v", err)
+	}
+	res := &ResourceConfig{HugetlbLimit: []*HugepageLimit{{PageSize: "2MB", Limit: 1 << 21}}}
+	if err := s.Set("mydocker/test", res); err == nil {
+		t.Fatal("expected error when setting hugetlb limits without the controller")
+	}
+}
@@ -3,9 +3,10 @@ package subsystems
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
 	"strconv"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type MemorySubsystem struct {
@@ -17,10 +18,25 @@ func (s *MemorySubsystem) Name() string {
 
 func (s *MemorySubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
-		if res.MemoryLimit != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"),
-				[]byte(res.MemoryLimit), 0644); err != nil {
-				return fmt.Errorf("set cgroup memory fail %v", err)
+		if IsCgroup2UnifiedMode() {
+			return setMemoryV2(subsysCgroupPath, res)
+		}
+		if err := setMemoryAndSwap(subsysCgroupPath, res); err != nil {
+			return err
+		}
+		if res.MemoryReservation != 0 {
+			if err := writeInt(subsysCgroupPath, "memory.soft_limit_in_bytes", res.MemoryReservation); err != nil {
+				return fmt.Errorf("set cgroup memory reservation fail %v", err)
+			}
+		}
+		if res.KernelMemory != 0 {
+			if err := writeInt(subsysCgroupPath, "memory.kmem.limit_in_bytes", res.KernelMemory); err != nil {
+				return fmt.Errorf("set cgroup kernel memory fail %v", err)
+			}
+		}
+		if res.OomKillDisable {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.oom_control"), []byte("1"), 0644); err != nil {
+				return fmt.Errorf("set cgroup oom kill disable fail %v", err)
 			}
 		}
 		return nil
@@ -31,19 +47,83 @@ func (s *MemorySubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (s *MemorySubsystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
-			return fmt.Errorf("set cgroup proc fail %v", err)
-		}
-		return nil
+		return applyPid(subsysCgroupPath, pid)
 	} else {
 		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
 	}
 }
 
 func (s *MemorySubsystem) Remove(cgroupPath string) error {
-	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		return os.Remove(subsysCgroupPath)
-	} else {
-		return err
+	return removeCgroup(s.Name(), cgroupPath)
+}
+
+// v1 中 memory.memsw.limit_in_bytes 必须不小于 memory.limit_in_bytes，
+// 所以先尝试设置内存限制，失败时（例如调小了swap）再按先swap后内存的顺序设置。
+// 使用systemd驱动时内存限制由systemd设置，这里只需要设置swap
+func setMemoryAndSwap(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.MemoryLimit == 0 {
+		if res.MemorySwap != 0 {
+			if err := writeInt(subsysCgroupPath, "memory.memsw.limit_in_bytes", res.MemorySwap); err != nil {
+				return fmt.Errorf("set cgroup memory swap fail %v", err)
+			}
+		}
+		return nil
+	}
+	if err := writeInt(subsysCgroupPath, "memory.limit_in_bytes", res.MemoryLimit); err != nil {
+		if res.MemorySwap == 0 {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+		if err := writeInt(subsysCgroupPath, "memory.memsw.limit_in_bytes", res.MemorySwap); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+		if err := writeInt(subsysCgroupPath, "memory.limit_in_bytes", res.MemoryLimit); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+		return nil
+	}
+	if res.MemorySwap != 0 {
+		if err := writeInt(subsysCgroupPath, "memory.memsw.limit_in_bytes", res.MemorySwap); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+	}
+	return nil
+}
+
+// v2 中 memory.swap.max 只限制swap本身，而 MemorySwap 是内存加swap的总量，需要换算
+func setMemoryV2(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.MemoryLimit != 0 {
+		if err := writeInt(subsysCgroupPath, "memory.max", res.MemoryLimit); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+		if res.MemorySwap != 0 {
+			swap := res.MemorySwap
+			if swap > 0 {
+				swap = res.MemorySwap - res.MemoryLimit
+			}
+			if err := writeInt(subsysCgroupPath, "memory.swap.max", swap); err != nil {
+				return fmt.Errorf("set cgroup memory swap fail %v", err)
+			}
+		}
+	}
+	if res.MemoryReservation != 0 {
+		if err := writeInt(subsysCgroupPath, "memory.low", res.MemoryReservation); err != nil {
+			return fmt.Errorf("set cgroup memory reservation fail %v", err)
+		}
+	}
+	if res.KernelMemory != 0 {
+		log.Warnf("kernel memory limit is not supported in cgroup v2, ignored")
+	}
+	if res.OomKillDisable {
+		log.Warnf("oom kill disable is not supported in cgroup v2, ignored")
+	}
+	return nil
+}
+
+// 写入一个整数值，v2 中小于0的值写成 max 表示不限制
+func writeInt(subsysCgroupPath, file string, value int64) error {
+	content := strconv.FormatInt(value, 10)
+	if value < 0 && IsCgroup2UnifiedMode() {
+		content = "max"
 	}
+	return ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(content), 0644)
 }
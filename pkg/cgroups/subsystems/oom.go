@@ -0,0 +1,128 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// 监听cgroup中的OOM事件，每次发生OOM时向返回的channel发送通知，cgroup被删除后channel关闭
+// v1 通过 cgroup.event_control 注册 memory.oom_control 的eventfd，v2 通过inotify监听 memory.events
+func (s *MemorySubsystem) NotifyOOM(cgroupPath string) (<-chan struct{}, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if IsCgroup2UnifiedMode() {
+		return notifyOOMV2(subsysCgroupPath)
+	}
+	return notifyOOMV1(subsysCgroupPath)
+}
+
+func notifyOOMV1(subsysCgroupPath string) (<-chan struct{}, error) {
+	oomControl, err := os.Open(path.Join(subsysCgroupPath, "memory.oom_control"))
+	if err != nil {
+		return nil, err
+	}
+	efd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		oomControl.Close()
+		return nil, fmt.Errorf("create eventfd error %v", err)
+	}
+	eventControl := path.Join(subsysCgroupPath, "cgroup.event_control")
+	data := fmt.Sprintf("%d %d", efd, oomControl.Fd())
+	if err := ioutil.WriteFile(eventControl, []byte(data), 0700); err != nil {
+		unix.Close(efd)
+		oomControl.Close()
+		return nil, fmt.Errorf("register oom event error %v", err)
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			close(ch)
+			unix.Close(efd)
+			oomControl.Close()
+		}()
+		buf := make([]byte, 8)
+		for {
+			if _, err := unix.Read(efd, buf); err != nil {
+				return
+			}
+			// cgroup被删除时也会触发eventfd，此时 cgroup.event_control 已经不存在
+			if _, err := os.Stat(eventControl); os.IsNotExist(err) {
+				return
+			}
+			notify(ch)
+		}
+	}()
+	return ch, nil
+}
+
+func notifyOOMV2(subsysCgroupPath string) (<-chan struct{}, error) {
+	eventsFile := path.Join(subsysCgroupPath, "memory.events")
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init error %v", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, eventsFile, unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("watch %s error %v", eventsFile, err)
+	}
+	lastCount, err := oomKillCount(eventsFile)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			close(ch)
+			unix.Close(fd)
+		}()
+		buf := make([]byte, unix.SizeofInotifyEvent+unix.PathMax+1)
+		for {
+			n, err := unix.Read(fd, buf)
+			if err != nil || n < unix.SizeofInotifyEvent {
+				return
+			}
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+			mask := event.Mask
+			// 文件被删除（cgroup被删除）后不会再有事件
+			if mask&unix.IN_IGNORED != 0 {
+				return
+			}
+			count, err := oomKillCount(eventsFile)
+			if err != nil {
+				return
+			}
+			// memory.events 中其他计数的变化也会触发事件，只关心 oom_kill
+			if count > lastCount {
+				lastCount = count
+				notify(ch)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func oomKillCount(eventsFile string) (uint64, error) {
+	values, err := readKeyValues(eventsFile)
+	if err != nil {
+		return 0, err
+	}
+	return values["oom_kill"], nil
+}
+
+// channel中已经有未处理的通知时不再重复发送
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,152 @@
+package subsystems
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// cgroup 中记录的资源使用情况
+type Stats struct {
+	CpuUsage    uint64 `json:"cpuUsage"` // 累计使用的CPU时间，单位纳秒
+	MemoryUsage uint64 `json:"memoryUsage"`
+	MemoryLimit uint64 `json:"memoryLimit"` // 0表示不限制
+	PidsCurrent uint64 `json:"pidsCurrent"`
+	PidsLimit   uint64 `json:"pidsLimit"` // 0表示不限制
+	BlkioRead   uint64 `json:"blkioRead"`
+	BlkioWrite  uint64 `json:"blkioWrite"`
+}
+
+// 能够读取资源使用情况的subsystem
+type StatsSubsystem interface {
+	GetStats(path string, stats *Stats) error
+}
+
+func (s *MemorySubsystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	usageFile, limitFile, statFile, inactiveKey := "memory.usage_in_bytes", "memory.limit_in_bytes", "memory.stat", "total_inactive_file"
+	if IsCgroup2UnifiedMode() {
+		usageFile, limitFile, inactiveKey = "memory.current", "memory.max", "inactive_file"
+	}
+	if stats.MemoryUsage, err = readUint(path.Join(subsysCgroupPath, usageFile)); err != nil {
+		return err
+	}
+	if stats.MemoryLimit, err = readUint(path.Join(subsysCgroupPath, limitFile)); err != nil {
+		return err
+	}
+	// 与 docker 一致，内存用量不计算可回收的 inactive page cache
+	if values, err := readKeyValues(path.Join(subsysCgroupPath, statFile)); err == nil {
+		if inactive := values[inactiveKey]; inactive < stats.MemoryUsage {
+			stats.MemoryUsage -= inactive
+		}
+	}
+	return nil
+}
+
+func (s *PidsSubsystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if stats.PidsCurrent, err = readUint(path.Join(subsysCgroupPath, "pids.current")); err != nil {
+		return err
+	}
+	stats.PidsLimit, err = readUint(path.Join(subsysCgroupPath, "pids.max"))
+	return err
+}
+
+func (s *BlkioSubsystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2UnifiedMode() {
+		// io.stat 每行格式为 "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0"
+		return scanLines(path.Join(subsysCgroupPath, "io.stat"), func(fields []string) {
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseUint(kv[1], 10, 64)
+				switch kv[0] {
+				case "rbytes":
+					stats.BlkioRead += value
+				case "wbytes":
+					stats.BlkioWrite += value
+				}
+			}
+		})
+	}
+	// blkio.throttle.io_service_bytes 每行格式为 "8:0 Read 1459200"，最后一行为 "Total 1773973504"
+	return scanLines(path.Join(subsysCgroupPath, "blkio.throttle.io_service_bytes"), func(fields []string) {
+		if len(fields) != 3 {
+			return
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	})
+}
+
+// 读取只包含一个数值的cgroup文件，"max" 以及 v1 中表示不限制的极大值都返回0
+func readUint(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s error %v", file, err)
+	}
+	// v1 中不限制时为 9223372036854771712 这样接近 int64 上限的值
+	if n >= 1<<62 {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// 读取 "key value" 格式的cgroup文件，例如 memory.stat、cpu.stat
+func readKeyValues(file string) (map[string]uint64, error) {
+	values := map[string]uint64{}
+	err := scanLines(file, func(fields []string) {
+		if len(fields) != 2 {
+			return
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	})
+	return values, err
+}
+
+func scanLines(file string, fn func(fields []string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			fn(fields)
+		}
+	}
+	return scanner.Err()
+}
@@ -3,16 +3,52 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
+	"mydocker/pkg/devices"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 )
 
-// 用于传递资源限制配置的结构体，包含内存限制、CPU时间片权重、CPU核心数
+// cgroup2 文件系统的magic number，见 linux/magic.h
+const cgroup2SuperMagic = 0x63677270
+
+var (
+	// cgroup v2 统一层级的默认挂载点，测试时可以替换成临时目录
+	unifiedMountpoint = "/sys/fs/cgroup"
+
+	isUnifiedOnce sync.Once
+	isUnified     bool
+)
+
+// 用于传递资源限制配置的结构体，包含内存限制、CPU时间片权重、CPU核心数、最大进程数
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare    string
-	CpuSet      string
+	// 内存相关的限制，单位均为字节，0表示不设置，小于0表示不限制
+	MemoryLimit       int64 `json:"memoryLimit,omitempty"`
+	MemorySwap        int64 `json:"memorySwap,omitempty"` // 内存加swap的总量
+	MemoryReservation int64 `json:"memoryReservation,omitempty"`
+	KernelMemory      int64 `json:"kernelMemory,omitempty"`
+	OomKillDisable    bool  `json:"oomKillDisable,omitempty"`
+
+	CpuShare  string  `json:"cpuShare,omitempty"`
+	CpuSet    string  `json:"cpuSet,omitempty"`
+	CpuQuota  int64   `json:"cpuQuota,omitempty"`  // 每个周期内可使用的CPU时间（微秒），小于0表示不限制
+	CpuPeriod uint64  `json:"cpuPeriod,omitempty"` // CFS调度周期（微秒）
+	Cpus      float64 `json:"cpus,omitempty"`      // 通过 --cpus 指定的CPU个数，修改周期时据此重新计算quota
+	PidsLimit int64   `json:"pidsLimit,omitempty"` // 0表示不设置，小于0表示不限制
+	// 块设备IO权重 [10-1000] 以及按设备的读写速率限制
+	BlkioWeight          uint16            `json:"blkioWeight,omitempty"`
+	BlkioDeviceReadBps   []*ThrottleDevice `json:"blkioDeviceReadBps,omitempty"`
+	BlkioDeviceWriteBps  []*ThrottleDevice `json:"blkioDeviceWriteBps,omitempty"`
+	BlkioDeviceReadIOps  []*ThrottleDevice `json:"blkioDeviceReadIOps,omitempty"`
+	BlkioDeviceWriteIOps []*ThrottleDevice `json:"blkioDeviceWriteIOps,omitempty"`
+	// 按大页大小限制hugetlb用量
+	HugetlbLimit []*HugepageLimit `json:"hugetlbLimit,omitempty"`
+	// 允许容器访问的设备白名单，nil 表示不限制
+	Devices []*devices.Device `json:"devices,omitempty"`
 }
 
 // 子系统接口，这里将cgroup抽象成了path，原因是cgroup在hierarchy的路径，便是虚拟文件系统中的虚拟路径
@@ -32,16 +68,46 @@ var (
 		&CpusetSubsystem{},
 		&MemorySubsystem{},
 		&CpuSubSystem{},
+		&CpuacctSubsystem{},
+		&PidsSubsystem{},
+		&BlkioSubsystem{},
+		&HugetlbSubsystem{},
+		&FreezerSubsystem{},
+		&DevicesSubsystem{},
+	}
+	// v1 的subsystem名称和 v2 控制器名称不一致的映射，空字符串表示 v2 中不需要启用控制器
+	cgroup2Controllers = map[string]string{
+		"blkio":   "io",
+		"cpuacct": "",
+		"freezer": "",
+		"devices": "",
 	}
 )
 
+// 判断宿主机是否只挂载了 cgroup v2（unified hierarchy），结果只需计算一次
+func IsCgroup2UnifiedMode() bool {
+	isUnifiedOnce.Do(func() {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(unifiedMountpoint, &st); err != nil {
+			isUnified = false
+			return
+		}
+		isUnified = st.Type == cgroup2SuperMagic
+	})
+	return isUnified
+}
+
 // 得到cgroup在文件系统中的绝对路径
 func GetCgroupPath(subsystem, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountpoint(subsystem)
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup subsystem %s not mounted", subsystem)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
-		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err != nil {
-				return "", fmt.Errorf("error create cgroup %v", err)
+		// v2 下各subsystem共用同一个目录，目录已经存在时也要启用当前subsystem的控制器
+		if os.IsNotExist(err) || autoCreate && IsCgroup2UnifiedMode() {
+			if err := createCgroup(subsystem, cgroupRoot, cgroupPath); err != nil {
+				return "", err
 			}
 		}
 		return path.Join(cgroupRoot, cgroupPath), nil
@@ -52,7 +118,11 @@ func GetCgroupPath(subsystem, cgroupPath string, autoCreate bool) (string, error
 
 // 找出挂载了某个subsystem的cgroup的根节点目录
 // 例如：44 33 0:38 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:21 - cgroup cgroup rw,memory
+// cgroup v2 下所有subsystem共用同一个挂载点
 func FindCgroupMountpoint(subsystem string) string {
+	if IsCgroup2UnifiedMode() {
+		return unifiedMountpoint
+	}
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
 		return ""
@@ -74,3 +144,132 @@ func FindCgroupMountpoint(subsystem string) string {
 	}
 	return ""
 }
+
+// 逐级创建cgroup目录，cgroupPath 可以是 --cgroup-parent 指定的多级路径
+func createCgroup(subsystem, cgroupRoot, cgroupPath string) error {
+	current := ""
+	for _, dir := range strings.Split(path.Clean(cgroupPath), "/") {
+		if dir == "" {
+			continue
+		}
+		current = path.Join(current, dir)
+		// v2 下子cgroup能否使用某个控制器，取决于父cgroup的 cgroup.subtree_control，
+		// 目录可能已经由其他subsystem创建，每一级都需要启用
+		if IsCgroup2UnifiedMode() {
+			if err := enableController(cgroupRoot, current, subsystem); err != nil {
+				return err
+			}
+		}
+		if _, err := os.Stat(path.Join(cgroupRoot, current)); err == nil {
+			continue
+		}
+		if err := os.Mkdir(path.Join(cgroupRoot, current), 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("error create cgroup %v", err)
+		}
+		// v1 中新建的 cpuset cgroup 的 cpus 和 mems 为空，此时无法加入进程，需要从父cgroup继承
+		if subsystem == "cpuset" && !IsCgroup2UnifiedMode() {
+			if err := inheritCpuset(path.Join(cgroupRoot, current)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func inheritCpuset(cgroupDir string) error {
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		content, err := ioutil.ReadFile(path.Join(path.Dir(cgroupDir), file))
+		if err != nil {
+			return fmt.Errorf("read parent %s error %v", file, err)
+		}
+		if err := ioutil.WriteFile(path.Join(cgroupDir, file), content, 0644); err != nil {
+			return fmt.Errorf("inherit %s error %v", file, err)
+		}
+	}
+	return nil
+}
+
+// 在cgroup v2中，把控制器写入父cgroup的 cgroup.subtree_control，子cgroup中才会出现对应的接口文件
+func enableController(cgroupRoot, cgroupPath, subsystem string) error {
+	if ctrl, ok := cgroup2Controllers[subsystem]; ok {
+		if ctrl == "" {
+			return nil
+		}
+		subsystem = ctrl
+	}
+	parent := path.Dir(path.Join(cgroupRoot, cgroupPath))
+	available, err := controllerAvailable(parent, subsystem)
+	if err != nil {
+		return err
+	}
+	// 内核没有启用或者上级没有下放的控制器无法启用，直接跳过，设置了对应的限制时由写入接口文件报错
+	if !available {
+		return nil
+	}
+	content, err := ioutil.ReadFile(path.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("read subtree_control of %s error %v", parent, err)
+	}
+	for _, ctrl := range strings.Fields(string(content)) {
+		if ctrl == subsystem {
+			return nil
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(parent, "cgroup.subtree_control"), []byte("+"+subsystem), 0644); err != nil {
+		return fmt.Errorf("enable controller %s in %s error %v", subsystem, parent, err)
+	}
+	return nil
+}
+
+// 判断subsystem在宿主机上是否可用：v1 中需要已经挂载，v2 中需要出现在根cgroup的 cgroup.controllers 中
+func subsystemAvailable(subsystem string) bool {
+	if !IsCgroup2UnifiedMode() {
+		return FindCgroupMountpoint(subsystem) != ""
+	}
+	if ctrl, ok := cgroup2Controllers[subsystem]; ok {
+		if ctrl == "" {
+			return true
+		}
+		subsystem = ctrl
+	}
+	available, err := controllerAvailable(unifiedMountpoint, subsystem)
+	return err == nil && available
+}
+
+// v2 中cgroup的 cgroup.controllers 列出了它可以下放给子cgroup的控制器
+func controllerAvailable(cgroupDir, controller string) (bool, error) {
+	content, err := ioutil.ReadFile(path.Join(cgroupDir, "cgroup.controllers"))
+	if err != nil {
+		return false, fmt.Errorf("read controllers of %s error %v", cgroupDir, err)
+	}
+	for _, ctrl := range strings.Fields(string(content)) {
+		if ctrl == controller {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 将进程加入cgroup，v1 写入 tasks 文件，v2 写入 cgroup.procs 文件
+func applyPid(subsysCgroupPath string, pid int) error {
+	procsFile := "tasks"
+	if IsCgroup2UnifiedMode() {
+		procsFile = "cgroup.procs"
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFile), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// 删除某个subsystem下的cgroup，v2 下各subsystem共用同一个目录，已经被删除时直接返回
+func removeCgroup(subsystem, cgroupPath string) error {
+	cgroupRoot := FindCgroupMountpoint(subsystem)
+	if cgroupRoot == "" {
+		return fmt.Errorf("cgroup subsystem %s not mounted", subsystem)
+	}
+	if err := os.Remove(path.Join(cgroupRoot, cgroupPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
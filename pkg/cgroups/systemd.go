@@ -0,0 +1,321 @@
+package cgroups
+
+import (
+	"fmt"
+	"math"
+	"mydocker/pkg/cgroups/subsystems"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	systemdDest         = "org.freedesktop.systemd1"
+	systemdPath         = "/org/freedesktop/systemd1"
+	systemdManagerIface = "org.freedesktop.systemd1.Manager"
+	systemdNoSuchUnit   = "org.freedesktop.systemd1.NoSuchUnit"
+	// 不指定 --cgroup-parent 时，容器的scope放在 system.slice 下
+	defaultSlice = "system.slice"
+	unitPrefix   = "mydocker-"
+	jobTimeout   = 30 * time.Second
+)
+
+// 连接systemd所在的D-Bus，测试时可以替换成本地启动的 dbus-daemon
+var systemdConn = dbus.SystemBus
+
+// systemd 单元属性，对应D-Bus签名 (sv)
+type systemdProperty struct {
+	Name  string
+	Value dbus.Variant
+}
+
+// StartTransientUnit 的最后一个参数，对应D-Bus签名 (sa(sv))
+type systemdAuxUnit struct {
+	Name       string
+	Properties []systemdProperty
+}
+
+// 通过systemd管理cgroup的驱动，每个容器对应一个 transient scope 单元，资源限制作为单元属性设置
+// systemd 无法表达的限制（cpuset、设备限速、hugetlb、内核内存、设备白名单等）以及 systemd 不管理的hierarchy仍通过cgroupfs设置
+type SystemdDriver struct {
+}
+
+func (d *SystemdDriver) Name() string {
+	return "systemd"
+}
+
+// 例如 parent 为 mydocker-app.slice，则路径为 mydocker.slice/mydocker-app.slice/mydocker-<id>.scope
+func (d *SystemdDriver) CgroupPath(parent, id string) (string, error) {
+	if parent == "" {
+		parent = defaultSlice
+	}
+	slicePath, err := expandSlice(parent)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(slicePath, unitPrefix+id+".scope"), nil
+}
+
+func (d *SystemdDriver) Apply(cgroupPath string, pid int, res *subsystems.ResourceConfig) error {
+	if err := startScope(cgroupPath, pid, res); err != nil {
+		return err
+	}
+	unitName, _ := unitOf(cgroupPath)
+	fs := &FsDriver{}
+	if res != nil {
+		if err := fs.Set(cgroupPath, fsResources(res)); err != nil {
+			return fmt.Errorf("set cgroupfs limits of %s error %v", unitName, err)
+		}
+	}
+	return fs.Apply(cgroupPath, pid, res)
+}
+
+func (d *SystemdDriver) Set(cgroupPath string, res *subsystems.ResourceConfig) error {
+	conn, err := systemdConn()
+	if err != nil {
+		return fmt.Errorf("connect to systemd error %v", err)
+	}
+	unitName, _ := unitOf(cgroupPath)
+	obj := conn.Object(systemdDest, systemdPath)
+	// run 中先 Set 后 Apply，此时单元还不存在，资源限制会在 Apply 创建单元时一起设置
+	var unitPath dbus.ObjectPath
+	if err := obj.Call(systemdManagerIface+".GetUnit", 0, unitName).Store(&unitPath); err != nil {
+		if isNoSuchUnit(err) {
+			return nil
+		}
+		return fmt.Errorf("get unit %s error %v", unitName, err)
+	}
+	if properties := resourceProperties(res); len(properties) > 0 {
+		if err := obj.Call(systemdManagerIface+".SetUnitProperties", 0, unitName, true, properties).Err; err != nil {
+			return fmt.Errorf("set unit %s properties error %v", unitName, err)
+		}
+	}
+	return (&FsDriver{}).Set(cgroupPath, fsResources(res))
+}
+
+func (d *SystemdDriver) Remove(cgroupPath string) error {
+	conn, err := systemdConn()
+	if err != nil {
+		return fmt.Errorf("connect to systemd error %v", err)
+	}
+	unitName, _ := unitOf(cgroupPath)
+	obj := conn.Object(systemdDest, systemdPath)
+	var job dbus.ObjectPath
+	if err := obj.Call(systemdManagerIface+".StopUnit", 0, unitName, "replace").Store(&job); err != nil && !isNoSuchUnit(err) {
+		log.Warnf("stop unit %s fail %v", unitName, err)
+	}
+	// 清理systemd不管理的hierarchy中由cgroupfs创建的目录
+	return (&FsDriver{}).Remove(cgroupPath)
+}
+
+// 为容器创建 scope 单元，把进程放进去，并把资源限制作为单元属性设置
+func startScope(cgroupPath string, pid int, res *subsystems.ResourceConfig) error {
+	conn, err := systemdConn()
+	if err != nil {
+		return fmt.Errorf("connect to systemd error %v", err)
+	}
+	unitName, slice := unitOf(cgroupPath)
+	properties := []systemdProperty{
+		newProperty("Description", "mydocker container "+unitName),
+		newProperty("Slice", slice),
+		newProperty("Delegate", true),
+		newProperty("PIDs", []uint32{uint32(pid)}),
+		newProperty("DefaultDependencies", false),
+	}
+	properties = append(properties, resourceProperties(res)...)
+	return startUnit(conn, unitName, properties)
+}
+
+// 创建 transient 单元，并等待对应的 job 执行完成
+func startUnit(conn *dbus.Conn, unitName string, properties []systemdProperty) error {
+	matchOptions := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(systemdPath),
+		dbus.WithMatchInterface(systemdManagerIface),
+		dbus.WithMatchMember("JobRemoved"),
+	}
+	if err := conn.AddMatchSignal(matchOptions...); err != nil {
+		return fmt.Errorf("add match signal error %v", err)
+	}
+	defer conn.RemoveMatchSignal(matchOptions...)
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	obj := conn.Object(systemdDest, systemdPath)
+	// systemd 只会给调用过 Subscribe 的客户端发送 JobRemoved 信号
+	if err := obj.Call(systemdManagerIface+".Subscribe", 0).Err; err != nil {
+		log.Debugf("subscribe systemd signals fail %v", err)
+	}
+	var job dbus.ObjectPath
+	if err := obj.Call(systemdManagerIface+".StartTransientUnit", 0,
+		unitName, "replace", properties, []systemdAuxUnit{}).Store(&job); err != nil {
+		return fmt.Errorf("start transient unit %s error %v", unitName, err)
+	}
+
+	timeout := time.After(jobTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			// JobRemoved 的参数为 (id uint32, job ObjectPath, unit string, result string)
+			if signal.Name != systemdManagerIface+".JobRemoved" || len(signal.Body) != 4 {
+				continue
+			}
+			if removedJob, ok := signal.Body[1].(dbus.ObjectPath); !ok || removedJob != job {
+				continue
+			}
+			if result, _ := signal.Body[3].(string); result != "done" {
+				return fmt.Errorf("start transient unit %s failed: %s", unitName, result)
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("wait for transient unit %s timeout", unitName)
+		}
+	}
+}
+
+// 把资源限制转换成systemd单元属性
+func resourceProperties(res *subsystems.ResourceConfig) []systemdProperty {
+	var properties []systemdProperty
+	if res == nil {
+		return properties
+	}
+	unified := subsystems.IsCgroup2UnifiedMode()
+	if res.MemoryLimit != 0 {
+		name := "MemoryLimit"
+		if unified {
+			name = "MemoryMax"
+		}
+		properties = append(properties, newProperty(name, limitValue(res.MemoryLimit)))
+	}
+	if unified && res.MemorySwap != 0 {
+		swap := res.MemorySwap
+		if swap > 0 {
+			swap = res.MemorySwap - res.MemoryLimit
+		}
+		properties = append(properties, newProperty("MemorySwapMax", limitValue(swap)))
+	}
+	if unified && res.MemoryReservation > 0 {
+		properties = append(properties, newProperty("MemoryLow", uint64(res.MemoryReservation)))
+	}
+	if res.CpuShare != "" {
+		if shares, err := strconv.ParseUint(res.CpuShare, 10, 64); err == nil {
+			if unified {
+				properties = append(properties, newProperty("CPUWeight", subsystems.ConvertSharesToWeight(shares)))
+			} else {
+				properties = append(properties, newProperty("CPUShares", shares))
+			}
+		}
+	}
+	if res.CpuQuota > 0 {
+		period := res.CpuPeriod
+		if period == 0 {
+			period = 100000
+		}
+		// systemd 以每秒可用的CPU时间表示quota，精度为 1%，即 10ms
+		quotaPerSec := uint64(res.CpuQuota) * 1000000 / period
+		if quotaPerSec%10000 != 0 {
+			quotaPerSec = (quotaPerSec/10000 + 1) * 10000
+		}
+		properties = append(properties, newProperty("CPUQuotaPerSecUSec", quotaPerSec))
+	} else if res.CpuQuota < 0 {
+		properties = append(properties, newProperty("CPUQuotaPerSecUSec", uint64(math.MaxUint64)))
+	}
+	if res.CpuPeriod != 0 {
+		properties = append(properties, newProperty("CPUQuotaPeriodUSec", res.CpuPeriod))
+	}
+	if res.PidsLimit != 0 {
+		properties = append(properties, newProperty("TasksMax", limitValue(res.PidsLimit)))
+	}
+	if res.BlkioWeight != 0 {
+		if unified {
+			properties = append(properties, newProperty("IOWeight", subsystems.ConvertBlkioToIoWeight(res.BlkioWeight)))
+		} else {
+			properties = append(properties, newProperty("BlockIOWeight", uint64(res.BlkioWeight)))
+		}
+	}
+	return properties
+}
+
+// 去掉已经通过 resourceProperties 作为单元属性设置的限制，剩下的才通过cgroupfs设置，
+// 避免systemd和cgroupfs同时管理同一个限制
+func fsResources(res *subsystems.ResourceConfig) *subsystems.ResourceConfig {
+	if res == nil {
+		return nil
+	}
+	fsRes := *res
+	unified := subsystems.IsCgroup2UnifiedMode()
+	fsRes.MemoryLimit = 0
+	if unified {
+		fsRes.MemorySwap = 0
+		fsRes.MemoryReservation = 0
+	}
+	if _, err := strconv.ParseUint(res.CpuShare, 10, 64); err == nil {
+		fsRes.CpuShare = ""
+	}
+	fsRes.CpuQuota = 0
+	fsRes.CpuPeriod = 0
+	fsRes.PidsLimit = 0
+	fsRes.BlkioWeight = 0
+	return &fsRes
+}
+
+func newProperty(name string, value interface{}) systemdProperty {
+	return systemdProperty{
+		Name:  name,
+		Value: dbus.MakeVariant(value),
+	}
+}
+
+// 小于0表示不限制，systemd 中用 uint64 的最大值表示 infinity
+func limitValue(value int64) uint64 {
+	if value < 0 {
+		return math.MaxUint64
+	}
+	return uint64(value)
+}
+
+// 从cgroup路径中取出scope单元名和它所在的slice
+func unitOf(cgroupPath string) (string, string) {
+	slice := path.Base(path.Dir(cgroupPath))
+	if slice == "." || slice == "/" {
+		slice = "-.slice"
+	}
+	return path.Base(cgroupPath), slice
+}
+
+// 把slice单元名展开成cgroup路径，systemd 用 "-" 表示层级关系
+// 例如 a-b-c.slice => a.slice/a-b.slice/a-b-c.slice
+func expandSlice(slice string) (string, error) {
+	const suffix = ".slice"
+	if !strings.HasSuffix(slice, suffix) || strings.Contains(slice, "/") {
+		return "", fmt.Errorf("invalid slice name %s", slice)
+	}
+	sliceName := strings.TrimSuffix(slice, suffix)
+	// -.slice 是根slice
+	if sliceName == "-" {
+		return "", nil
+	}
+	var slicePath, prefix string
+	for _, component := range strings.Split(sliceName, "-") {
+		if component == "" {
+			return "", fmt.Errorf("invalid slice name %s", slice)
+		}
+		slicePath = path.Join(slicePath, prefix+component+suffix)
+		prefix += component + "-"
+	}
+	return slicePath, nil
+}
+
+func isNoSuchUnit(err error) bool {
+	switch dbusErr := err.(type) {
+	case dbus.Error:
+		return dbusErr.Name == systemdNoSuchUnit
+	case *dbus.Error:
+		return dbusErr.Name == systemdNoSuchUnit
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"mydocker/pkg/cgroups/subsystems"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// 模拟 systemd 的 org.freedesktop.systemd1.Manager 接口，只记录收到的调用
+type fakeSystemd struct {
+	conn  *dbus.Conn
+	mu    sync.Mutex
+	jobs  uint32
+	units map[string][]systemdProperty
+}
+
+func (f *fakeSystemd) Subscribe() *dbus.Error {
+	return nil
+}
+
+func (f *fakeSystemd) StartTransientUnit(name, mode string, properties []systemdProperty,
+	aux []systemdAuxUnit) (dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs++
+	f.units[name] = properties
+	job := dbus.ObjectPath(fmt.Sprintf("%s/job/%d", systemdPath, f.jobs))
+	id := f.jobs
+	go f.conn.Emit(systemdPath, systemdManagerIface+".JobRemoved", id, job, name, "done")
+	return job, nil
+}
+
+func (f *fakeSystemd) GetUnit(name string) (dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.units[name]; !ok {
+		return "", dbus.NewError(systemdNoSuchUnit, []interface{}{"unit " + name + " not loaded"})
+	}
+	return dbus.ObjectPath(systemdPath + "/unit/" + strings.Replace(name, ".", "_2e", -1)), nil
+}
+
+func (f *fakeSystemd) SetUnitProperties(name string, runtime bool, properties []systemdProperty) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.units[name] = append(f.units[name], properties...)
+	return nil
+}
+
+func (f *fakeSystemd) StopUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.units[name]; !ok {
+		return "", dbus.NewError(systemdNoSuchUnit, []interface{}{"unit " + name + " not loaded"})
+	}
+	delete(f.units, name)
+	f.jobs++
+	return dbus.ObjectPath(fmt.Sprintf("%s/job/%d", systemdPath, f.jobs)), nil
+}
+
+func (f *fakeSystemd) property(unit, name string) (interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.units[unit] {
+		if p.Name == name {
+			return p.Value.Value(), true
+		}
+	}
+	return nil, false
+}
+
+// 启动一个本地的 dbus-daemon，并在上面注册模拟的systemd
+func startFakeSystemd(t *testing.T) *fakeSystemd {
+	daemonPath, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	daemon := exec.Command(daemonPath, "--session", "--nofork", "--print-address")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	address = strings.TrimSpace(address)
+
+	serverConn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { serverConn.Close() })
+	fake := &fakeSystemd{conn: serverConn, units: map[string][]systemdProperty{}}
+	if err := serverConn.Export(fake, systemdPath, systemdManagerIface); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := serverConn.RequestName(systemdDest, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name %s error %v", systemdDest, err)
+	}
+
+	clientConn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clientConn.Close() })
+	origConn := systemdConn
+	systemdConn = func() (*dbus.Conn, error) { return clientConn, nil }
+	t.Cleanup(func() { systemdConn = origConn })
+	return fake
+}
+
+func TestSystemdDriver(t *testing.T) {
+	fake := startFakeSystemd(t)
+	driver := &SystemdDriver{}
+
+	cgroupPath, err := driver.CgroupPath("mydocker-test.slice", "1234567890")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cgroupPath != "mydocker.slice/mydocker-test.slice/mydocker-1234567890.scope" {
+		t.Fatalf("unexpected cgroup path %s", cgroupPath)
+	}
+	unitName := "mydocker-1234567890.scope"
+
+	// 单元创建之前的 Set 不应该报错
+	res := &subsystems.ResourceConfig{PidsLimit: 100}
+	if err := driver.Set(cgroupPath, res); err != nil {
+		t.Fatal(err)
+	}
+	// 只创建scope单元，不通过cgroupfs修改宿主机上真实的cgroup
+	if err := startScope(cgroupPath, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if slice, _ := fake.property(unitName, "Slice"); slice != "mydocker-test.slice" {
+		t.Errorf("unexpected slice %v", slice)
+	}
+	if pids, _ := fake.property(unitName, "PIDs"); fmt.Sprint(pids) != "[1]" {
+		t.Errorf("unexpected pids %v", pids)
+	}
+
+	if err := driver.Remove(cgroupPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.property(unitName, "Slice"); ok {
+		t.Errorf("unit %s should be stopped", unitName)
+	}
+}
+
+func TestResourceProperties(t *testing.T) {
+	res := &subsystems.ResourceConfig{
+		CpuQuota:  150000,
+		CpuPeriod: 100000,
+		PidsLimit: -1,
+	}
+	properties := map[string]interface{}{}
+	for _, p := range resourceProperties(res) {
+		properties[p.Name] = p.Value.Value()
+	}
+	if quota := properties["CPUQuotaPerSecUSec"]; quota != uint64(1500000) {
+		t.Errorf("unexpected cpu quota %v", quota)
+	}
+	if tasks := properties["TasksMax"]; tasks != uint64(1<<64-1) {
+		t.Errorf("unexpected tasks max %v", tasks)
+	}
+	// 已经交给systemd的限制不应该再写入cgroupfs
+	if fsRes := fsResources(res); fsRes.CpuQuota != 0 || fsRes.CpuPeriod != 0 || fsRes.PidsLimit != 0 {
+		t.Errorf("limits managed by systemd are also set through cgroupfs: %+v", fsRes)
+	}
+}
+
+func TestExpandSlice(t *testing.T) {
+	cases := map[string]string{
+		"-.slice":        "",
+		"system.slice":   "system.slice",
+		"a-b-c.slice":    "a.slice/a-b.slice/a-b-c.slice",
+		"mydocker.slice": "mydocker.slice",
+		"test-abc.slice": "test.slice/test-abc.slice",
+	}
+	for slice, expected := range cases {
+		got, err := expandSlice(slice)
+		if err != nil || got != expected {
+			t.Errorf("expand %s got %s %v, expected %s", slice, got, err, expected)
+		}
+	}
+	for _, slice := range []string{"system", "a--b.slice", "a/b.slice"} {
+		if _, err := expandSlice(slice); err == nil {
+			t.Errorf("expand %s should fail", slice)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package command
+
+import (
+	"mydocker/pkg/capabilities"
+
+	"github.com/urfave/cli"
+)
+
+// run 和 exec 共用的capability参数
+var capabilityFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "cap-add",
+		Usage: "add a linux capability, e.g. NET_ADMIN or ALL",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-drop",
+		Usage: "drop a linux capability, e.g. CHOWN or ALL",
+	},
+	cli.BoolFlag{
+		Name:  "privileged",
+		Usage: "give all capabilities to the process",
+	},
+}
+
+// 根据 --privileged、--cap-add 和 --cap-drop 在 base 的基础上计算进程的capabilities
+func parseCapabilities(ctx *cli.Context, base []string) ([]string, error) {
+	if ctx.Bool("privileged") {
+		return capabilities.All(), nil
+	}
+	return capabilities.Merge(base, ctx.StringSlice("cap-add"), ctx.StringSlice("cap-drop"))
+}
@@ -3,8 +3,10 @@ package command
 import (
 	"errors"
 	"fmt"
+	"mydocker/pkg/capabilities"
 	"mydocker/pkg/cgroups/subsystems"
 	"mydocker/pkg/container"
+	"mydocker/pkg/devices"
 	"mydocker/pkg/network"
 	"os"
 
@@ -31,7 +33,7 @@ var InitCommand = cli.Command{
 var RunCommand = cli.Command{
 	Name:  "run",
 	Usage: `Create a container with namespace and cgroups limit mydocker run -it [command]`,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "ti",
 			Usage: "enable tty",
@@ -45,26 +47,71 @@ var RunCommand = cli.Command{
 			Usage: "detach container",
 		},
 		cli.StringFlag{
-			Name:  "m",
-			Usage: "memory limit",
+			Name:  "v",
+			Usage: "volume",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment",
 		},
 		cli.StringFlag{
-			Name:  "cpushare",
-			Usage: "cpushare limit",
+			Name:  "net",
+			Usage: "container network",
+		},
+		cli.StringSliceFlag{
+			Name:  "p",
+			Usage: "port mapping, e.g. 8080:80",
 		},
 		cli.StringFlag{
-			Name:  "cpuset",
-			Usage: "cpuset limit",
+			Name:  "cgroup-parent",
+			Usage: "optional parent cgroup for the container",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, e.g. /dev/sda:/dev/xvda:rwm",
 		},
 		cli.StringFlag{
-			Name:  "v",
-			Usage: "volume",
+			Name:  "hostname",
+			Usage: "container host name, defaults to the container ID",
+		},
+		cli.StringFlag{
+			Name:  "domainname",
+			Usage: "container NIS domain name",
+		},
+		cli.StringFlag{
+			Name:  "userns-remap",
+			Usage: "run the container in a user namespace using subordinate ids of a user, e.g. mydocker or mydocker:mydocker",
 		},
 		cli.StringSliceFlag{
-			Name:  "e",
-			Usage: "set environment",
+			Name:  "uidmap",
+			Usage: "uid mapping of the user namespace, e.g. 0:100000:65536",
 		},
-	},
+		cli.StringSliceFlag{
+			Name:  "gidmap",
+			Usage: "gid mapping of the user namespace, defaults to the uid mapping",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options, e.g. seccomp=profile.json, seccomp=unconfined or no-new-privileges",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or uid and optional group, e.g. nobody or 1000:1000",
+		},
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory inside the container, created if missing",
+		},
+		ulimitFlag,
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+	}, append(resourceFlags, capabilityFlags...)...),
 
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
@@ -83,23 +130,129 @@ var RunCommand = cli.Command{
 			return fmt.Errorf("ti and d paramter can not both provided")
 		}
 
-		resConf := &subsystems.ResourceConfig{
-			MemoryLimit: ctx.String("m"),
-			CpuSet:      ctx.String("cpuset"),
-			CpuShare:    ctx.String("cpushare"),
+		resConf := &subsystems.ResourceConfig{}
+		if err := parseResourceConfig(ctx, resConf); err != nil {
+			return err
+		}
+		// 容器只能访问默认设备和通过 --device 添加的设备
+		resConf.Devices = devices.DefaultRules()
+		for _, d := range ctx.StringSlice("device") {
+			device, err := devices.ParseDevice(d)
+			if err != nil {
+				return err
+			}
+			resConf.Devices = append(resConf.Devices, device)
+		}
+		// 特权容器可以访问所有设备
+		if ctx.Bool("privileged") {
+			resConf.Devices = append(resConf.Devices, &devices.Device{
+				Type: devices.AllDevices, Major: devices.Wildcard, Minor: devices.Wildcard, Permissions: "rwm",
+			})
+		}
+		caps, err := parseCapabilities(ctx, capabilities.DefaultCapabilities)
+		if err != nil {
+			return err
+		}
+		security, err := parseSecurityOpts(ctx.StringSlice("security-opt"), ctx.Bool("privileged"))
+		if err != nil {
+			return err
+		}
+		if err := checkWorkdir(ctx.String("workdir")); err != nil {
+			return err
+		}
+		ulimits, err := parseUlimits(ctx.StringSlice("ulimit"))
+		if err != nil {
+			return err
+		}
+		idMappings, err := parseIDMappings(ctx)
+		if err != nil {
+			return err
+		}
+		// rootless模式下使用 slirp4netns，不能加入网桥网络，也不能映射端口
+		if container.Rootless && (ctx.String("net") != "" || len(ctx.StringSlice("p")) > 0) {
+			return fmt.Errorf("net and p are not supported in rootless mode")
+		}
+		// 数据卷通过aufs挂载，普通用户没有权限
+		if container.Rootless && ctx.String("v") != "" {
+			return fmt.Errorf("v is not supported in rootless mode")
 		}
 		log.Infof("createTty %v", createTty)
 
-		containerName := ctx.String("name")
-		volume := ctx.String("v")
-		network := ctx.String("net")
-		envSlice := ctx.StringSlice("e")
-		portmapping := ctx.StringSlice("p")
-		run(createTty, cmdArray, resConf, volume, containerName, imageName, envSlice, network, portmapping)
+		run(&runOptions{
+			tty:           createTty,
+			cmdArray:      cmdArray,
+			res:           resConf,
+			containerName: ctx.String("name"),
+			volume:        ctx.String("v"),
+			imageName:     imageName,
+			envSlice:      ctx.StringSlice("e"),
+			network:       ctx.String("net"),
+			portmapping:   ctx.StringSlice("p"),
+			cgroupParent:  ctx.String("cgroup-parent"),
+			hostname:      ctx.String("hostname"),
+			domainname:    ctx.String("domainname"),
+			idMappings:    idMappings,
+			capabilities:  caps,
+			privileged:    ctx.Bool("privileged"),
+			securityOpt:   ctx.StringSlice("security-opt"),
+			security:      security,
+			readonly:      ctx.Bool("read-only"),
+			user:          ctx.String("user"),
+			workdir:       ctx.String("workdir"),
+			ulimits:       ulimits,
+			init:          ctx.Bool("init"),
+		})
 		return nil
 	},
 }
 
+var InspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information of a container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := ctx.Args().Get(0)
+		return inspectContainer(containerName)
+	},
+}
+
+var StatsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "display a live stream of container(s) resource usage statistics",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "disable streaming stats and only pull the first result",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "output format, table or json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return statsContainers(ctx.Args(), ctx.Bool("no-stream"), ctx.String("format"))
+	},
+}
+
+var UpdateCommand = cli.Command{
+	Name:  "update",
+	Usage: "update resource limits of a container, mydocker update [options] [container name]",
+	Flags: resourceFlags,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := ctx.Args().Get(0)
+		return updateContainer(containerName, ctx)
+	},
+}
+
 var ListCommand = cli.Command{
 	Name:  "ps",
 	Usage: "list all the containers",
@@ -139,11 +292,22 @@ var LogCommand = cli.Command{
 var ExecCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or uid and optional group, defaults to the user of the container",
+		},
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory inside the container, defaults to the workdir of the container",
+		},
+		ulimitFlag,
+	}, capabilityFlags...),
 	Action: func(ctx *cli.Context) error {
 		//This is for callback
 		if os.Getenv(ENV_EXEC_PID) != "" {
-			log.Infof("pid callback pid %s", os.Getgid())
-			return nil
+			log.Infof("pid callback pid %d", os.Getpid())
+			return container.RunContainerExecProcess()
 		}
 
 		if len(ctx.Args()) < 2 {
@@ -152,7 +316,23 @@ var ExecCommand = cli.Command{
 		containerName := ctx.Args().Get(0)
 		var commandArray []string
 		commandArray = append(commandArray, ctx.Args().Tail()...)
-		execContainer(containerName, commandArray)
+		if err := checkWorkdir(ctx.String("workdir")); err != nil {
+			return err
+		}
+		ulimits, err := parseUlimits(ctx.StringSlice("ulimit"))
+		if err != nil {
+			return err
+		}
+		execContainer(&execOptions{
+			containerName: containerName,
+			cmdArray:      commandArray,
+			capAdd:        ctx.StringSlice("cap-add"),
+			capDrop:       ctx.StringSlice("cap-drop"),
+			privileged:    ctx.Bool("privileged"),
+			user:          ctx.String("user"),
+			workdir:       ctx.String("workdir"),
+			ulimits:       ulimits,
+		})
 		return nil
 	},
 }
@@ -170,6 +350,30 @@ var StopCommand = cli.Command{
 	},
 }
 
+var PauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "pause all processes within a container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := ctx.Args().Get(0)
+		return pauseContainer(containerName)
+	},
+}
+
+var UnpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "unpause all processes within a container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := ctx.Args().Get(0)
+		return unpauseContainer(containerName)
+	},
+}
+
 var RemoveCommand = cli.Command{
 	Name:  "rm",
 	Usage: "remove unused containers",
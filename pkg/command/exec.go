@@ -4,41 +4,109 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mydocker/pkg/capabilities"
 	"mydocker/pkg/container"
 	"os"
 	"os/exec"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
 const ENV_EXEC_PID = "mydocker_pid"
-const ENV_EXEC_CMD = "mydocker_cmd"
 
-func execContainer(containerName string, comArray []string) {
-	pid, err := getContainerPidByName(containerName)
+// exec 命令的参数
+type execOptions struct {
+	containerName string
+	cmdArray      []string
+	capAdd        []string
+	capDrop       []string
+	privileged    bool
+	user          string
+	workdir       string
+	ulimits       []*container.Rlimit
+}
+
+// 通过环境变量 mydocker_pid 触发 nsenter 进入容器的namespace，
+// 命令和配置通过管道传给进入容器后的子进程，由它切换capabilities后执行命令
+func execContainer(opts *execOptions) {
+	containerInfo, err := getContainerInfoByName(opts.containerName)
 	if err != nil {
-		log.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
+		log.Errorf("Exec container getContainerInfoByName %s error %v", opts.containerName, err)
 		return
 	}
-
-	cmdStr := strings.Join(comArray, " ")
+	pid := containerInfo.Pid
 	log.Infof("container pid %s", pid)
-	log.Infof("command %s", cmdStr)
+	log.Infof("command %q", opts.cmdArray)
 
+	caps, err := execCapabilities(containerInfo, opts)
+	if err != nil {
+		log.Errorf("Exec container %s error %v", opts.containerName, err)
+		return
+	}
+	profile, err := readSeccompProfile(opts.containerName)
+	if err != nil {
+		log.Errorf("Exec container %s read seccomp profile error %v", opts.containerName, err)
+		return
+	}
+
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		log.Errorf("new pipe error %v", err)
+		return
+	}
 	cmd := exec.Command("/proc/self/exe", "exec")
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readPipe}
+	cmd.Env = append(os.Environ(), ENV_EXEC_PID+"="+pid)
 
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...)
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Exec container %s error %v", opts.containerName, err)
+		return
+	}
+	readPipe.Close()
+	// 没有指定用户和工作目录时与容器相同
+	user, workdir := opts.user, opts.workdir
+	if user == "" {
+		user = containerInfo.User
+	}
+	if workdir == "" {
+		workdir = containerInfo.WorkingDir
+	}
+	// nsenter 出来的进程继承的是宿主机上的资源限制，需要设置成容器的，再加上 --ulimit 指定的
+	rlimits := container.MergeRlimits(containerInfo.Ulimits, opts.ulimits)
+	execConfig := &container.InitConfig{
+		// 使用记录的容器环境变量，使用 --init 时1号进程的环境变量不是用户进程的。
+		// 没有记录的老容器由进入容器后的子进程从1号进程读取
+		Args:         opts.cmdArray,
+		Env:          containerInfo.Env,
+		Cwd:          workdir,
+		User:         user,
+		Rlimits:      rlimits,
+		Capabilities: caps,
+		Seccomp:      profile,
+		// 与容器一样设置 no_new_privs
+		NoNewPrivileges: noNewPrivileges(containerInfo.SecurityOpt),
+	}
+	if err := container.SendInitConfig(execConfig, writePipe); err != nil {
+		log.Error(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		log.Errorf("Exec container %s error %v", opts.containerName, err)
+	}
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Errorf("Exec container %s error %v", containerName, err)
+// exec 的进程默认与容器的capabilities相同，可以再通过参数调整。
+// 没有记录capabilities的老容器在没有指定参数时保持不变
+func execCapabilities(containerInfo *container.ContainerInfo, opts *execOptions) ([]string, error) {
+	if opts.privileged {
+		return capabilities.All(), nil
+	}
+	if containerInfo.Capabilities == nil && len(opts.capAdd) == 0 && len(opts.capDrop) == 0 {
+		return nil, nil
 	}
+	return capabilities.Merge(containerInfo.Capabilities, opts.capAdd, opts.capDrop)
 }
 
 func getContainerPidByName(containerName string) (string, error) {
@@ -54,15 +122,3 @@ func getContainerPidByName(containerName string) (string, error) {
 	}
 	return containerInfo.Pid, nil
 }
-
-func getEnvsByPid(pid string) []string {
-	path := fmt.Sprintf("/proc/%s/environ", pid)
-	contentBytes, err := ioutil.ReadFile(path)
-	if err != nil {
-		log.Errorf("Read file %s error %v", path, err)
-		return nil
-	}
-	//env split by \u0000
-	envs := strings.Split(string(contentBytes), "\u0000")
-	return envs
-}
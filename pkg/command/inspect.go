@@ -0,0 +1,20 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func inspectContainer(containerName string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	content, err := json.MarshalIndent(containerInfo, "", "    ")
+	if err != nil {
+		return fmt.Errorf("json marshal container %s info error %v", containerName, err)
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
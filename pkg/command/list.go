@@ -41,7 +41,7 @@ func listContainers() {
 			item.Id,
 			item.Name,
 			item.Pid,
-			item.Status,
+			containerStatus(item),
 			item.Command,
 			item.CreatedTime)
 	}
@@ -68,3 +68,11 @@ func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
 
 	return &containerInfo, nil
 }
+
+// 已经退出的容器显示退出码，例如 Exited (137)
+func containerStatus(containerInfo *container.ContainerInfo) string {
+	if containerInfo.Status == container.EXIT {
+		return fmt.Sprintf("Exited (%d)", containerInfo.ExitCode)
+	}
+	return containerInfo.Status
+}
@@ -0,0 +1,37 @@
+package command
+
+import (
+	"fmt"
+	"mydocker/pkg/cgroups/subsystems"
+	"mydocker/pkg/container"
+)
+
+// 通过 freezer 冻结容器中的所有进程
+func pauseContainer(containerName string) error {
+	return updateContainerInfo(containerName, func(containerInfo *container.ContainerInfo) error {
+		if containerInfo.Status != container.RUNNING {
+			return fmt.Errorf("container %s is not running", containerName)
+		}
+		cgroupManager := containerCgroupManager(containerInfo)
+		if err := cgroupManager.Freeze(subsystems.Frozen); err != nil {
+			return fmt.Errorf("pause container %s error %v", containerName, err)
+		}
+		containerInfo.Status = container.PAUSED
+		return nil
+	})
+}
+
+// 解冻容器中的所有进程
+func unpauseContainer(containerName string) error {
+	return updateContainerInfo(containerName, func(containerInfo *container.ContainerInfo) error {
+		if containerInfo.Status != container.PAUSED {
+			return fmt.Errorf("container %s is not paused", containerName)
+		}
+		cgroupManager := containerCgroupManager(containerInfo)
+		if err := cgroupManager.Freeze(subsystems.Thawed); err != nil {
+			return fmt.Errorf("unpause container %s error %v", containerName, err)
+		}
+		containerInfo.Status = container.RUNNING
+		return nil
+	})
+}
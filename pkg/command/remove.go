@@ -14,7 +14,7 @@ func removeContainer(containerName string) {
 		log.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	if containerInfo.Status != container.STOP {
+	if containerInfo.Status != container.STOP && containerInfo.Status != container.EXIT {
 		log.Errorf("Couldn't remove running container")
 		return
 	}
@@ -24,4 +24,6 @@ func removeContainer(containerName string) {
 		return
 	}
 	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	// 容器已经停止，删除它的cgroup
+	containerCgroupManager(containerInfo).RemoveAll()
 }
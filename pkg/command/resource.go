@@ -0,0 +1,318 @@
+package command
+
+import (
+	"fmt"
+	"mydocker/pkg/cgroups/subsystems"
+	"mydocker/pkg/units"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// CFS调度的默认周期，单位微秒
+const defaultCpuPeriod = 100000
+
+// run 和 update 共用的资源限制参数
+var resourceFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "m",
+		Usage: "memory limit, e.g. 512m or 1g",
+	},
+	cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "total limit of memory plus swap, '-1' to enable unlimited swap",
+	},
+	cli.StringFlag{
+		Name:  "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	cli.StringFlag{
+		Name:  "kernel-memory",
+		Usage: "kernel memory limit",
+	},
+	cli.BoolFlag{
+		Name:  "oom-kill-disable",
+		Usage: "disable OOM killer",
+	},
+	cli.StringFlag{
+		Name:  "cpushare",
+		Usage: "cpushare limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset",
+		Usage: "cpuset limit",
+	},
+	cli.Int64Flag{
+		Name:  "cpu-quota",
+		Usage: "limit CPU CFS quota (microseconds)",
+	},
+	cli.Uint64Flag{
+		Name:  "cpu-period",
+		Usage: "limit CPU CFS period (microseconds)",
+	},
+	cli.Float64Flag{
+		Name:  "cpus",
+		Usage: "number of CPUs, e.g. 1.5",
+	},
+	cli.Int64Flag{
+		Name:  "pids-limit",
+		Usage: "tune container pids limit (set -1 for unlimited)",
+	},
+	cli.UintFlag{
+		Name:  "blkio-weight",
+		Usage: "block IO relative weight, between 10 and 1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "limit read rate (bytes per second) from a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "limit write rate (bytes per second) to a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-iops",
+		Usage: "limit read rate (IO per second) from a device, e.g. /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-iops",
+		Usage: "limit write rate (IO per second) to a device, e.g. /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "hugetlb-limit",
+		Usage: "limit hugepage usage of a page size, e.g. 2MB:1g",
+	},
+}
+
+// 从命令行参数中解析出资源限制配置，只有显式指定的参数才会覆盖 resConf 中原有的值
+func parseResourceConfig(ctx *cli.Context, resConf *subsystems.ResourceConfig) error {
+	if ctx.IsSet("oom-kill-disable") {
+		resConf.OomKillDisable = ctx.Bool("oom-kill-disable")
+	}
+	if ctx.IsSet("cpuset") {
+		resConf.CpuSet = ctx.String("cpuset")
+	}
+	if ctx.IsSet("cpushare") {
+		resConf.CpuShare = ctx.String("cpushare")
+	}
+	if ctx.IsSet("cpu-quota") {
+		resConf.CpuQuota = ctx.Int64("cpu-quota")
+		// 显式指定的quota覆盖之前通过 --cpus 设置的值
+		resConf.Cpus = 0
+	}
+	if ctx.IsSet("cpu-period") {
+		resConf.CpuPeriod = ctx.Uint64("cpu-period")
+	}
+	if ctx.IsSet("pids-limit") {
+		resConf.PidsLimit = ctx.Int64("pids-limit")
+	}
+	if err := parseMemoryConfig(ctx, resConf); err != nil {
+		return err
+	}
+	// --cpus 是 quota/period 的简便写法，例如 1.5 表示每 100ms 周期内可以使用 150ms 的CPU时间
+	if ctx.IsSet("cpus") {
+		cpus := ctx.Float64("cpus")
+		if ctx.IsSet("cpu-quota") {
+			return fmt.Errorf("cpus and cpu-quota can not both provided")
+		}
+		if cpus <= 0 {
+			return fmt.Errorf("invalid cpus %v", cpus)
+		}
+		resConf.Cpus = cpus
+	}
+	// 记录了CPU个数时按当前周期重新计算quota，update 只修改 --cpu-period 时CPU个数保持不变
+	if resConf.Cpus > 0 {
+		if resConf.CpuPeriod == 0 {
+			resConf.CpuPeriod = defaultCpuPeriod
+		}
+		resConf.CpuQuota = int64(resConf.Cpus * float64(resConf.CpuPeriod))
+	}
+
+	if ctx.IsSet("blkio-weight") {
+		weight := ctx.Uint("blkio-weight")
+		if weight < 10 || weight > 1000 {
+			return fmt.Errorf("blkio-weight %d should be in range [10, 1000]", weight)
+		}
+		resConf.BlkioWeight = uint16(weight)
+	}
+	throttles := []struct {
+		flag    string
+		bps     bool
+		devices *[]*subsystems.ThrottleDevice
+	}{
+		{"device-read-bps", true, &resConf.BlkioDeviceReadBps},
+		{"device-write-bps", true, &resConf.BlkioDeviceWriteBps},
+		{"device-read-iops", false, &resConf.BlkioDeviceReadIOps},
+		{"device-write-iops", false, &resConf.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		if !ctx.IsSet(throttle.flag) {
+			continue
+		}
+		devices, err := parseThrottleDevices(ctx.StringSlice(throttle.flag), throttle.bps)
+		if err != nil {
+			return err
+		}
+		*throttle.devices = mergeThrottleDevices(*throttle.devices, devices)
+	}
+
+	if ctx.IsSet("hugetlb-limit") {
+		limits, err := parseHugetlbLimits(ctx.StringSlice("hugetlb-limit"))
+		if err != nil {
+			return err
+		}
+		resConf.HugetlbLimit = mergeHugetlbLimits(resConf.HugetlbLimit, limits)
+	}
+	return nil
+}
+
+// 解析并校验内存相关的参数，容量支持 512m、1g 这样的写法
+func parseMemoryConfig(ctx *cli.Context, resConf *subsystems.ResourceConfig) error {
+	var err error
+	if ctx.IsSet("m") {
+		if resConf.MemoryLimit, err = parseMemorySize(ctx.String("m")); err != nil {
+			return fmt.Errorf("invalid memory limit: %v", err)
+		}
+	}
+	if ctx.IsSet("memory-reservation") {
+		if resConf.MemoryReservation, err = parseMemorySize(ctx.String("memory-reservation")); err != nil {
+			return fmt.Errorf("invalid memory reservation: %v", err)
+		}
+	}
+	if ctx.IsSet("kernel-memory") {
+		if resConf.KernelMemory, err = parseMemorySize(ctx.String("kernel-memory")); err != nil {
+			return fmt.Errorf("invalid kernel memory: %v", err)
+		}
+	}
+	if ctx.IsSet("memory-swap") {
+		if swap := ctx.String("memory-swap"); swap == "-1" {
+			resConf.MemorySwap = -1
+		} else if resConf.MemorySwap, err = parseMemorySize(swap); err != nil {
+			return fmt.Errorf("invalid memory swap: %v", err)
+		}
+	}
+
+	if resConf.MemorySwap != 0 {
+		if resConf.MemoryLimit == 0 {
+			return fmt.Errorf("you should always set the memory limit when using memory swap")
+		}
+		if resConf.MemorySwap > 0 && resConf.MemorySwap < resConf.MemoryLimit {
+			return fmt.Errorf("minimum memory swap limit should be larger than memory limit")
+		}
+	}
+	if resConf.MemoryLimit != 0 && resConf.MemoryReservation > resConf.MemoryLimit {
+		return fmt.Errorf("minimum memory limit should be larger than memory reservation limit")
+	}
+	if resConf.OomKillDisable && resConf.MemoryLimit == 0 {
+		log.Warnf("disable OOM killer without setting memory limit is dangerous")
+	}
+	return nil
+}
+
+func parseMemorySize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(size)
+}
+
+// 解析形如 /dev/sda:1mb 的设备限速参数，bps 为 true 时速率支持容量写法
+func parseThrottleDevices(values []string, bps bool) ([]*subsystems.ThrottleDevice, error) {
+	var devices []*subsystems.ThrottleDevice
+	for _, value := range values {
+		idx := strings.LastIndex(value, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid device rate %s, should be <device-path>:<rate>", value)
+		}
+		var rate uint64
+		if bps {
+			size, err := units.RAMInBytes(value[idx+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate of device %s: %v", value, err)
+			}
+			rate = uint64(size)
+		} else {
+			var err error
+			if rate, err = strconv.ParseUint(value[idx+1:], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid rate of device %s: %v", value, err)
+			}
+		}
+		td, err := subsystems.NewThrottleDevice(value[:idx], rate)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, td)
+	}
+	return devices, nil
+}
+
+// 合并设备限速配置，同一设备以新的配置为准
+func mergeThrottleDevices(old, new []*subsystems.ThrottleDevice) []*subsystems.ThrottleDevice {
+	var merged []*subsystems.ThrottleDevice
+	for _, td := range old {
+		overridden := false
+		for _, newTd := range new {
+			if newTd.Major == td.Major && newTd.Minor == td.Minor {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, td)
+		}
+	}
+	return append(merged, new...)
+}
+
+// 解析形如 2MB:1g 的大页限制参数，大页大小必须是内核支持的
+func parseHugetlbLimits(values []string) ([]*subsystems.HugepageLimit, error) {
+	var limits []*subsystems.HugepageLimit
+	for _, value := range values {
+		idx := strings.Index(value, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid hugetlb limit %s, should be <page-size>:<limit>", value)
+		}
+		pageSize, err := units.RAMInBytes(value[:idx])
+		if err != nil || pageSize <= 0 {
+			return nil, fmt.Errorf("invalid hugepage size of %s", value)
+		}
+		sizeName := subsystems.HugePageSizeName(uint64(pageSize))
+		supported := subsystems.HugePageSizes()
+		found := false
+		for _, size := range supported {
+			if size == sizeName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("hugepage size %s is not supported, supported sizes are %v", sizeName, supported)
+		}
+		limit, err := units.RAMInBytes(value[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hugetlb limit of %s: %v", value, err)
+		}
+		limits = append(limits, &subsystems.HugepageLimit{PageSize: sizeName, Limit: uint64(limit)})
+	}
+	return limits, nil
+}
+
+// 合并大页限制，同一大页大小以新的配置为准
+func mergeHugetlbLimits(old, new []*subsystems.HugepageLimit) []*subsystems.HugepageLimit {
+	var merged []*subsystems.HugepageLimit
+	for _, limit := range old {
+		overridden := false
+		for _, newLimit := range new {
+			if newLimit.PageSize == limit.PageSize {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, limit)
+		}
+	}
+	return append(merged, new...)
+}
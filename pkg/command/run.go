@@ -7,8 +7,10 @@ import (
 	"mydocker/pkg/cgroups"
 	"mydocker/pkg/cgroups/subsystems"
 	"mydocker/pkg/container"
+	"mydocker/pkg/devices"
 	"mydocker/pkg/network"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -16,68 +18,209 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func run(tty bool, cmdArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string,
-	envSlice []string, nw string, portmapping []string) {
+// run 命令的参数
+type runOptions struct {
+	tty           bool
+	cmdArray      []string
+	res           *subsystems.ResourceConfig
+	containerName string
+	volume        string
+	imageName     string
+	envSlice      []string
+	network       string
+	portmapping   []string
+	cgroupParent  string
+	hostname      string
+	domainname    string
+	idMappings    *container.IDMappings
+	capabilities  []string
+	privileged    bool
+	securityOpt   []string
+	security      *securityOptions
+	readonly      bool
+	user          string
+	workdir       string
+	ulimits       []*container.Rlimit
+	init          bool
+}
+
+func run(opts *runOptions) {
+	// 后台容器交给shim创建并等待退出
+	var ready *os.File
+	if !opts.tty {
+		if !isShim() {
+			if err := startShim(); err != nil {
+				log.Errorf("run container error %v", err)
+			}
+			return
+		}
+		ready = shimReadyFile()
+		// 不要把shim的标记传给容器
+		os.Unsetenv(shimEnv)
+	}
+
 	containerID := randStringBytes(10)
-	if containerName == "" {
-		containerName = containerID
+	if opts.containerName == "" {
+		opts.containerName = containerID
+	}
+	// 默认使用容器ID作为主机名
+	if opts.hostname == "" {
+		opts.hostname = containerID
 	}
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice)
+	containerName := opts.containerName
+	parent, writePipe := container.NewParentProcess(opts.tty, containerName, opts.volume, opts.imageName, opts.idMappings)
 	if parent == nil {
 		log.Errorf("new parent process error")
 		return
 	}
-	if err := parent.Start(); err != nil {
+	if err := container.StartParentProcess(parent, containerName); err != nil {
 		log.Error(err)
+		return
+	}
+	reexec := false
+	if opts.idMappings != nil && opts.idMappings.NeedIDMapHelper() {
+		if err := container.WriteIDMappings(parent.Process.Pid, opts.idMappings); err != nil {
+			log.Error(err)
+			parent.Process.Kill()
+			return
+		}
+		reexec = true
+	}
+
+	// use containerID as cgroup name, 可以通过 --cgroup-parent 放到指定的父cgroup下
+	cgroupPath, err := cgroups.CgroupPath(opts.cgroupParent, containerID)
+	if err != nil {
+		log.Errorf("get cgroup path error %v", err)
+		return
 	}
 
 	// 记录容器信息
-	containerName, err := recordContainerInfo(parent.Process.Pid, cmdArray, containerName, containerID, volume)
+	containerName, err = recordContainerInfo(parent.Process.Pid, containerID, opts, cgroupPath)
 	if err != nil {
 		log.Errorf("record container info error %v", err)
 		return
 	}
+	if err := writeSeccompProfile(containerName, opts.security.seccomp); err != nil {
+		log.Errorf("write seccomp profile error %v", err)
+		return
+	}
 
-	// use containerID as cgroup name
-	cgroupManager := cgroups.NewCgroupManager(containerID)
-	defer cgroupManager.RemoveAll()
-	cgroupManager.SetAll(res)
-	cgroupManager.ApplyAll(parent.Process.Pid)
+	// cgroup 的生命周期跟随容器，后台运行的容器在 rm 时才删除cgroup
+	cgroupManager := cgroups.NewCgroupManager(cgroupPath)
+	var oomCh <-chan struct{}
+	if container.Rootless {
+		// 普通用户没有权限创建cgroup，rootless模式下不限制资源
+		log.Warnf("resource limits are ignored in rootless mode")
+	} else {
+		if err := applyCgroup(cgroupManager, opts.res, parent.Process.Pid); err != nil {
+			// 资源限制没有生效时不能让容器继续运行
+			log.Errorf("set container %s resource limits error %v", containerName, err)
+			parent.Process.Kill()
+			parent.Wait()
+			cgroupManager.RemoveAll()
+			deleteContainerInfo(containerName)
+			container.DeleteWorkSpace(opts.volume, containerName)
+			return
+		}
+		if oomCh, err = cgroupManager.NotifyOOM(); err != nil {
+			log.Warnf("watch oom event error %v", err)
+		}
+	}
 
-	if nw != "" {
+	if container.Rootless {
+		// rootless模式下使用 slirp4netns 提供网络，没有安装时容器只有回环网络
+		slirp, err := network.StartSlirp(parent.Process.Pid)
+		if err != nil {
+			log.Warnf("container has no network: %v", err)
+		}
+		defer network.StopSlirp(slirp)
+	} else if opts.network != "" {
 		// config container network
 		network.Init()
 		containerInfo := &container.ContainerInfo{
 			Id:          containerID,
 			Pid:         strconv.Itoa(parent.Process.Pid),
 			Name:        containerName,
-			PortMapping: portmapping,
+			PortMapping: opts.portmapping,
 		}
-		if err := network.Connect(nw, containerInfo); err != nil {
+		if err := network.Connect(opts.network, containerInfo); err != nil {
 			log.Errorf("Error Connect Network %v", err)
 			return
 		}
 	}
 
-	sendInitCommand(cmdArray, writePipe)
-	if tty {
+	initConfig := &container.InitConfig{
+		Args:       opts.cmdArray,
+		Env:        containerEnv(opts),
+		Cwd:        opts.workdir,
+		User:       opts.user,
+		Rlimits:    opts.ulimits,
+		Hostname:   opts.hostname,
+		Domainname: opts.domainname,
+		Rootfs:     fmt.Sprintf(container.MntUrl, containerName),
+		Mounts:     container.DefaultMounts(opts.idMappings),
+		Devices:    deviceNodes(opts.res.Devices),
+		// 容器进程的effective、permitted、inheritable、ambient和bounding集合
+		Capabilities: opts.capabilities,
+		Seccomp:      opts.security.seccomp,
+		// no_new_privs 和只读的根文件系统
+		NoNewPrivileges: opts.security.noNewPrivileges,
+		ReadonlyRootfs:  opts.readonly,
+		// rootless模式下可能需要由init挂载rootfs
+		RootfsMount: container.RootfsMount(containerName, opts.imageName),
+		Reexec:      reexec,
+		Init:        opts.init,
+	}
+	// 特权容器不屏蔽任何路径
+	if !opts.privileged {
+		initConfig.MaskedPaths = container.DefaultMaskedPaths
+		initConfig.ReadonlyPaths = container.DefaultReadonlyPaths
+	}
+	log.Infof("command all is %q", opts.cmdArray)
+	if err := container.SendInitConfig(initConfig, writePipe); err != nil {
+		log.Error(err)
+		return
+	}
+	if opts.tty {
 		parent.Wait()
+		cgroupManager.RemoveAll()
 		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+		container.DeleteWorkSpace(opts.volume, containerName)
+		return
 	}
+	notifyShimReady(ready, containerID)
+	waitContainer(parent, containerName, oomCh)
 }
 
-func recordContainerInfo(containerPID int, commandArray []string, containerName, id, volume string) (string, error) {
+func recordContainerInfo(containerPID int, id string, opts *runOptions, cgroupPath string) (string, error) {
+	containerName := opts.containerName
 	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
+	command := strings.Join(opts.cmdArray, "")
 	containerInfo := &container.ContainerInfo{
 		Id:          id,
 		Pid:         strconv.Itoa(containerPID),
 		Command:     command,
 		CreatedTime: createTime,
+		StartedAt:   createTime,
 		Status:      container.RUNNING,
 		Name:        containerName,
-		Volume:      volume,
+		Volume:      opts.volume,
+		Hostname:    opts.hostname,
+		Domainname:  opts.domainname,
+		// 保存资源限制，便于之后通过 inspect 查看
+		ResourceConfig: opts.res,
+		CgroupPath:     cgroupPath,
+		CgroupDriver:   cgroups.DefaultDriverName(),
+		IDMappings:     opts.idMappings,
+		Capabilities:   opts.capabilities,
+		Privileged:     opts.privileged,
+		SecurityOpt:    opts.securityOpt,
+		ReadonlyRootfs: opts.readonly,
+		User:           opts.user,
+		WorkingDir:     opts.workdir,
+		Ulimits:        opts.ulimits,
+		Init:           opts.init,
+		Env:            containerEnv(opts),
 	}
 
 	jsonBytes, err := json.Marshal(containerInfo)
@@ -88,7 +231,7 @@ func recordContainerInfo(containerPID int, commandArray []string, containerName,
 	jsonStr := string(jsonBytes)
 
 	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	if err := os.MkdirAll(dirUrl, 0622); err != nil {
+	if err := os.MkdirAll(dirUrl, 0755); err != nil {
 		log.Errorf("Mkdir error %s error %v", dirUrl, err)
 		return "", err
 	}
@@ -110,11 +253,35 @@ func recordContainerInfo(containerPID int, commandArray []string, containerName,
 	return containerName, nil
 }
 
-func sendInitCommand(cmdArray []string, writePipe *os.File) {
-	command := strings.Join(cmdArray, " ")
-	log.Infof("command all is %s", command)
-	writePipe.WriteString(command)
-	writePipe.Close()
+// 容器进程的环境变量，继承 run 命令的环境变量并加上 -e 指定的
+func containerEnv(opts *runOptions) []string {
+	return append(os.Environ(), opts.envSlice...)
+}
+
+func applyCgroup(cgroupManager *cgroups.CgroupManager, res *subsystems.ResourceConfig, pid int) error {
+	if err := cgroupManager.SetAll(res); err != nil {
+		return err
+	}
+	return cgroupManager.ApplyAll(pid)
+}
+
+// 工作目录必须是容器中的绝对路径
+func checkWorkdir(workdir string) error {
+	if workdir != "" && !filepath.IsAbs(workdir) {
+		return fmt.Errorf("workdir %s is not an absolute path", workdir)
+	}
+	return nil
+}
+
+// 有路径的设备需要在容器中创建设备节点，只有通配的访问规则没有对应的设备文件
+func deviceNodes(rules []*devices.Device) []*devices.Device {
+	var nodes []*devices.Device
+	for _, d := range rules {
+		if d.Path != "" {
+			nodes = append(nodes, d)
+		}
+	}
+	return nodes
 }
 
 func randStringBytes(n int) string {
@@ -0,0 +1,123 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mydocker/pkg/container"
+	"mydocker/pkg/seccomp"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// --security-opt 解析后的安全选项
+type securityOptions struct {
+	// 为nil时不限制系统调用
+	seccomp         *seccomp.Profile
+	noNewPrivileges bool
+}
+
+// 解析 --security-opt，支持 seccomp=<profile.json|unconfined> 和 no-new-privileges[=true|false]。
+// 没有指定时使用默认的seccomp配置；与Docker一样，特权容器不限制系统调用
+func parseSecurityOpts(opts []string, privileged bool) (*securityOptions, error) {
+	security := &securityOptions{}
+	if !privileged {
+		if seccomp.Supported() {
+			security.seccomp = seccomp.DefaultProfile()
+		} else {
+			log.Warnf("seccomp is not supported on %s, running without the default seccomp profile", runtime.GOARCH)
+		}
+	}
+	for _, opt := range opts {
+		key, value := splitSecurityOpt(opt)
+		switch key {
+		case "seccomp":
+			if value == "" {
+				return nil, fmt.Errorf("invalid security-opt %s", opt)
+			}
+			if value == "unconfined" {
+				security.seccomp = nil
+				continue
+			}
+			if privileged {
+				log.Warnf("seccomp profile %s is ignored for privileged container", value)
+				continue
+			}
+			if !seccomp.Supported() {
+				return nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+			}
+			profile, err := seccomp.LoadProfile(value)
+			if err != nil {
+				return nil, err
+			}
+			security.seccomp = profile
+		case "no-new-privileges":
+			enabled, err := parseNoNewPrivileges(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid security-opt %s", opt)
+			}
+			security.noNewPrivileges = enabled
+		default:
+			return nil, fmt.Errorf("unknown security-opt %s", opt)
+		}
+	}
+	return security, nil
+}
+
+// exec 的进程与容器一样设置 no_new_privs，参数在 run 时已经检查过
+func noNewPrivileges(opts []string) bool {
+	enabled := false
+	for _, opt := range opts {
+		if key, value := splitSecurityOpt(opt); key == "no-new-privileges" {
+			enabled, _ = parseNoNewPrivileges(value)
+		}
+	}
+	return enabled
+}
+
+func splitSecurityOpt(opt string) (string, string) {
+	kv := strings.SplitN(opt, "=", 2)
+	if len(kv) == 1 {
+		return kv[0], ""
+	}
+	return kv[0], kv[1]
+}
+
+func parseNoNewPrivileges(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// 保存容器的seccomp配置，exec 时读取同一个配置，unconfined 时不保存
+func writeSeccompProfile(containerName string, profile *seccomp.Profile) error {
+	if profile == nil {
+		return nil
+	}
+	content, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf(container.DefaultInfoLocation, containerName)+container.SeccompProfileName, content, 0644)
+}
+
+// 读取容器的seccomp配置，没有保存时返回nil
+func readSeccompProfile(containerName string) (*seccomp.Profile, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.SeccompProfileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var profile seccomp.Profile
+	if err := json.Unmarshal(content, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mydocker/pkg/container"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// 标记当前进程是后台容器的shim
+	shimEnv = "MYDOCKER_SHIM"
+	// shim 通过这个文件描述符通知 run 命令容器已经启动
+	shimReadyFd = 3
+	// 容器被 SIGKILL 杀死时，等待OOM事件送达的时间
+	oomEventTimeout = 100 * time.Millisecond
+)
+
+func isShim() bool {
+	return os.Getenv(shimEnv) == "1"
+}
+
+// 后台运行的容器需要有进程等待它退出并记录退出状态，这里以新的会话重新执行当前命令作为shim，
+// 等shim创建好容器后 run 命令就可以返回了
+func startShim() error {
+	exe, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return fmt.Errorf("get current executable error %v", err)
+	}
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyRead.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), shimEnv+"=1")
+	// 容器启动之前的日志和错误仍然输出到当前终端
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readyWrite}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		readyWrite.Close()
+		return fmt.Errorf("start shim error %v", err)
+	}
+	readyWrite.Close()
+
+	containerID, _ := ioutil.ReadAll(readyRead)
+	if len(containerID) == 0 {
+		cmd.Wait()
+		return fmt.Errorf("shim exited before the container started")
+	}
+	log.Infof("container %s started", containerID)
+	return cmd.Process.Release()
+}
+
+// shim 中不能把通知管道泄漏给之后创建的子进程，否则 run 命令读不到EOF
+func shimReadyFile() *os.File {
+	syscall.CloseOnExec(shimReadyFd)
+	return os.NewFile(uintptr(shimReadyFd), "ready")
+}
+
+// 通知 run 命令容器已经启动，之后 shim 脱离终端，只在后台等待容器退出
+func notifyShimReady(ready *os.File, containerID string) {
+	if _, err := ready.WriteString(containerID); err != nil {
+		log.Errorf("notify container started error %v", err)
+	}
+	ready.Close()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		log.Errorf("open %s error %v", os.DevNull, err)
+		return
+	}
+	defer devNull.Close()
+	for _, fd := range []int{0, 1, 2} {
+		if err := unix.Dup3(int(devNull.Fd()), fd, 0); err != nil {
+			log.Errorf("redirect fd %d error %v", fd, err)
+		}
+	}
+}
+
+// 等待容器退出，把退出码以及是否因为OOM被杀死记录到容器信息中
+func waitContainer(parent *exec.Cmd, containerName string, oomCh <-chan struct{}) {
+	parent.Wait()
+	status, ok := parent.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok {
+		log.Errorf("get container %s exit status error", containerName)
+		return
+	}
+	exitCode := status.ExitStatus()
+	if status.Signaled() {
+		exitCode = 128 + int(status.Signal())
+	}
+
+	oomKilled := false
+	if oomCh != nil {
+		// OOM killer 使用 SIGKILL，这时事件可能稍晚于进程退出送达
+		timeout := time.Duration(0)
+		if status.Signaled() && status.Signal() == syscall.SIGKILL {
+			timeout = oomEventTimeout
+		}
+		select {
+		case _, received := <-oomCh:
+			oomKilled = received
+		case <-time.After(timeout):
+		}
+	}
+
+	err := updateContainerInfo(containerName, func(containerInfo *container.ContainerInfo) error {
+		containerInfo.Status = container.EXIT
+		containerInfo.Pid = " "
+		containerInfo.ExitCode = exitCode
+		containerInfo.OOMKilled = oomKilled
+		containerInfo.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Write container %s info error %v", containerName, err)
+	}
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"mydocker/pkg/container"
+	"mydocker/pkg/network"
+	"mydocker/pkg/units"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 两次采样之间的间隔
+const statsInterval = time.Second
+
+// 一次采样得到的容器资源使用情况
+type containerStats struct {
+	Name          string  `json:"name"`
+	Id            string  `json:"id"`
+	CpuPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetworkRx     uint64  `json:"networkRx"`
+	NetworkTx     uint64  `json:"networkTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+	Pids          uint64  `json:"pids"`
+
+	cpuUsage uint64
+	readAt   time.Time
+}
+
+func statsContainers(containerNames []string, noStream bool, format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %s", format)
+	}
+	var containerInfos []*container.ContainerInfo
+	for _, containerName := range containerNames {
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil {
+			return fmt.Errorf("get container %s info error %v", containerName, err)
+		}
+		if containerInfo.Status != container.RUNNING {
+			return fmt.Errorf("container %s is not running", containerName)
+		}
+		containerInfos = append(containerInfos, containerInfo)
+	}
+
+	// CPU使用率需要根据两次采样之间的差值计算，所以先采样一次
+	prev := make([]*containerStats, len(containerInfos))
+	for i, containerInfo := range containerInfos {
+		prev[i] = readContainerStats(containerInfo)
+	}
+	for {
+		time.Sleep(statsInterval)
+		current := make([]*containerStats, len(containerInfos))
+		for i, containerInfo := range containerInfos {
+			current[i] = readContainerStats(containerInfo)
+			current[i].CpuPercent = calculateCpuPercent(prev[i], current[i])
+		}
+		if format == "json" {
+			printStatsJson(current)
+		} else {
+			if !noStream {
+				// 清屏并把光标移到左上角，实现刷新效果
+				fmt.Fprint(os.Stdout, "\033[2J\033[H")
+			}
+			printStatsTable(current)
+		}
+		if noStream {
+			return nil
+		}
+		prev = current
+	}
+}
+
+func readContainerStats(containerInfo *container.ContainerInfo) *containerStats {
+	cgroupManager := containerCgroupManager(containerInfo)
+	cgroupStats := cgroupManager.GetStats()
+	stats := &containerStats{
+		Name:        containerInfo.Name,
+		Id:          containerInfo.Id,
+		MemoryUsage: cgroupStats.MemoryUsage,
+		MemoryLimit: cgroupStats.MemoryLimit,
+		BlockRead:   cgroupStats.BlkioRead,
+		BlockWrite:  cgroupStats.BlkioWrite,
+		Pids:        cgroupStats.PidsCurrent,
+		cpuUsage:    cgroupStats.CpuUsage,
+		readAt:      time.Now(),
+	}
+	if stats.MemoryLimit != 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+	if networkStats, err := network.GetNetworkStats(containerInfo.Pid); err == nil {
+		stats.NetworkRx = networkStats.RxBytes
+		stats.NetworkTx = networkStats.TxBytes
+	} else {
+		log.Warnf("get container %s network stats error %v", containerInfo.Name, err)
+	}
+	return stats
+}
+
+// 与 docker stats 一致，100% 表示占满一个CPU核心
+func calculateCpuPercent(prev, current *containerStats) float64 {
+	wallDelta := current.readAt.Sub(prev.readAt).Nanoseconds()
+	if wallDelta <= 0 || current.cpuUsage < prev.cpuUsage {
+		return 0
+	}
+	return float64(current.cpuUsage-prev.cpuUsage) / float64(wallDelta) * 100
+}
+
+func printStatsTable(statsList []*containerStats) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, item := range statsList {
+		memLimit := "unlimited"
+		if item.MemoryLimit != 0 {
+			memLimit = units.BytesSize(float64(item.MemoryLimit))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			item.Id,
+			item.Name,
+			item.CpuPercent,
+			units.BytesSize(float64(item.MemoryUsage)), memLimit,
+			item.MemoryPercent,
+			units.BytesSize(float64(item.NetworkRx)), units.BytesSize(float64(item.NetworkTx)),
+			units.BytesSize(float64(item.BlockRead)), units.BytesSize(float64(item.BlockWrite)),
+			item.Pids)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+	}
+}
+
+// 每次采样每个容器输出一行json，方便其他程序逐行解析
+func printStatsJson(statsList []*containerStats) {
+	encoder := json.NewEncoder(os.Stdout)
+	for _, item := range statsList {
+		if err := encoder.Encode(item); err != nil {
+			log.Errorf("Json encode stats error %v", err)
+		}
+	}
+}
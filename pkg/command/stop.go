@@ -4,13 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mydocker/pkg/cgroups"
+	"mydocker/pkg/cgroups/subsystems"
 	"mydocker/pkg/container"
+	"os"
 	"strconv"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// 等待容器响应 SIGTERM 退出的时间
+const stopTimeout = 10 * time.Second
+
 func stopContainer(containerName string) {
 	// 根据容器名获取对应的主进程PID
 	pid, err := getContainerPidByName(containerName)
@@ -35,21 +42,87 @@ func stopContainer(containerName string) {
 		log.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	// 至此，容器进程已经被kill，所以下面需要修改容器状态，PID可以置为空
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = " "
+	// 被冻结的进程不会处理信号，解冻之后 SIGTERM 才会送达
+	if containerInfo.Status == container.PAUSED {
+		if err := containerCgroupManager(containerInfo).Freeze(subsystems.Thawed); err != nil {
+			log.Errorf("Unpause container %s error %v", containerName, err)
+		}
+	}
+	// 容器的退出状态由等待它的进程记录，这里等它退出，超时则强制杀死
+	if containerInfo.StartedAt != "" {
+		if !waitContainerExit(containerName, stopTimeout) {
+			log.Warnf("container %s did not exit in %v, killing it", containerName, stopTimeout)
+			syscall.Kill(pidInt, syscall.SIGKILL)
+			waitContainerExit(containerName, stopTimeout)
+		}
+		return
+	}
+	// 旧版本创建的容器没有等待它的进程，容器进程已经被kill，直接修改容器状态，PID可以置为空
+	err = updateContainerInfo(containerName, func(containerInfo *container.ContainerInfo) error {
+		containerInfo.Status = container.STOP
+		containerInfo.Pid = " "
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Write container %s info error %v", containerName, err)
+	}
+}
+
+// 等待容器退出状态被记录，前台容器退出后容器信息会被删除，同样视为已经退出
+func waitContainerExit(containerName string, timeout time.Duration) bool {
+	configFilePath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ConfigName
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		content, err := ioutil.ReadFile(configFilePath)
+		if os.IsNotExist(err) {
+			return true
+		}
+		var containerInfo container.ContainerInfo
+		if err == nil && json.Unmarshal(content, &containerInfo) == nil && containerInfo.Status == container.EXIT {
+			return true
+		}
+	}
+	return false
+}
+
+// 修改容器信息时使用的锁文件，与 config.json 放在同一目录
+const infoLockName = "config.lock"
+
+// 在文件锁的保护下读取、修改并写回容器信息，避免 pause、update 和等待容器退出的进程同时修改时互相覆盖
+func updateContainerInfo(containerName string, update func(*container.ContainerInfo) error) error {
+	lockPath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + infoLockName
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open lock file %s error %v", lockPath, err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s error %v", lockPath, err)
+	}
+	// 关闭文件时锁自动释放
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error %v", containerName, err)
+	}
+	if err := update(containerInfo); err != nil {
+		return err
+	}
+	return writeContainerInfo(containerInfo)
+}
+
+// 把修改后的容器信息写回 config.json
+func writeContainerInfo(containerInfo *container.ContainerInfo) error {
 	// 将修改后的信息序列化成json的字符串
 	newContentBytes, err := json.Marshal(containerInfo)
 	if err != nil {
-		log.Error("Json marshal %s error %v", containerName, err)
-		return
+		return fmt.Errorf("json marshal %s error %v", containerInfo.Name, err)
 	}
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	configFilePath := dirURL + container.ConfigName
 	// 重新写入新的数据覆盖原来的信息
 	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		log.Errorf("Write file %s error", configFilePath, err)
+		return fmt.Errorf("write file %s error %v", configFilePath, err)
 	}
+	return nil
 }
 
 func getContainerInfoByName(containerName string) (*container.ContainerInfo, error) {
@@ -64,8 +137,24 @@ func getContainerInfoByName(containerName string) (*container.ContainerInfo, err
 	var containerInfo container.ContainerInfo
 	// 将容器信息字符串反序列化成对应的对象
 	if err := json.Unmarshal(contentBytes, &containerInfo); err != nil {
-		log.Error("GetContainerInfoByName unmarshal error %v", err)
+		log.Errorf("GetContainerInfoByName unmarshal error %v", err)
 		return nil, err
 	}
 	return &containerInfo, nil
 }
+
+// 获取容器的cgroup管理器，没有记录 cgroupPath 的旧容器使用容器ID作为cgroup路径
+func containerCgroupManager(containerInfo *container.ContainerInfo) *cgroups.CgroupManager {
+	cgroupPath := containerInfo.CgroupPath
+	if cgroupPath == "" {
+		cgroupPath = containerInfo.Id
+	}
+	cgroupManager := cgroups.NewCgroupManager(cgroupPath)
+	// 使用创建容器时的cgroup驱动
+	if containerInfo.CgroupDriver != "" {
+		if driver, err := cgroups.GetDriver(containerInfo.CgroupDriver); err == nil {
+			cgroupManager.Driver = driver
+		}
+	}
+	return cgroupManager
+}
@@ -0,0 +1,25 @@
+package command
+
+import (
+	"mydocker/pkg/container"
+
+	"github.com/urfave/cli"
+)
+
+// run 和 exec 共用的资源限制参数
+var ulimitFlag = cli.StringSliceFlag{
+	Name:  "ulimit",
+	Usage: "set a resource limit, e.g. nofile=1024:4096, can be repeated",
+}
+
+func parseUlimits(values []string) ([]*container.Rlimit, error) {
+	var rlimits []*container.Rlimit
+	for _, v := range values {
+		r, err := container.ParseRlimit(v)
+		if err != nil {
+			return nil, err
+		}
+		rlimits = append(rlimits, r)
+	}
+	return container.MergeRlimits(nil, rlimits), nil
+}
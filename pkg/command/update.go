@@ -0,0 +1,41 @@
+package command
+
+import (
+	"fmt"
+	"mydocker/pkg/cgroups/subsystems"
+	"mydocker/pkg/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func updateContainer(containerName string, ctx *cli.Context) error {
+	err := updateContainerInfo(containerName, func(containerInfo *container.ContainerInfo) error {
+		// 在原有配置的基础上修改，未指定的参数保持不变
+		resConf := &subsystems.ResourceConfig{}
+		if containerInfo.ResourceConfig != nil {
+			*resConf = *containerInfo.ResourceConfig
+		}
+		if err := parseResourceConfig(ctx, resConf); err != nil {
+			return err
+		}
+
+		// 容器运行中或者暂停时cgroup都存在，把新的限制直接写入容器的cgroup
+		if containerInfo.Status == container.RUNNING || containerInfo.Status == container.PAUSED {
+			// update 不能修改设备规则，重新写入时 v1 会先拒绝所有设备，运行中的容器会短暂地无法访问设备
+			liveConf := *resConf
+			liveConf.Devices = nil
+			cgroupManager := containerCgroupManager(containerInfo)
+			if err := cgroupManager.SetAll(&liveConf); err != nil {
+				return fmt.Errorf("update container %s cgroup error %v", containerName, err)
+			}
+		}
+		containerInfo.ResourceConfig = resConf
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("container %s resource updated", containerName)
+	return nil
+}
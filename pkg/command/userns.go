@@ -0,0 +1,64 @@
+package command
+
+import (
+	"fmt"
+	"mydocker/pkg/container"
+
+	"github.com/urfave/cli"
+)
+
+// 解析 --userns-remap、--uidmap 和 --gidmap 参数，都没有指定时返回nil，容器不使用user namespace。
+// rootless模式下总是使用当前用户和它的从属ID
+func parseIDMappings(ctx *cli.Context) (*container.IDMappings, error) {
+	remap := ctx.String("userns-remap")
+	uidMaps := ctx.StringSlice("uidmap")
+	gidMaps := ctx.StringSlice("gidmap")
+	if container.Rootless {
+		if remap != "" || len(uidMaps) > 0 || len(gidMaps) > 0 {
+			return nil, fmt.Errorf("userns-remap and uidmap/gidmap are not supported in rootless mode")
+		}
+		return container.RootlessIDMappings()
+	}
+
+	var idMappings *container.IDMappings
+	switch {
+	case remap != "":
+		if len(uidMaps) > 0 || len(gidMaps) > 0 {
+			return nil, fmt.Errorf("userns-remap and uidmap/gidmap can not both provided")
+		}
+		var err error
+		if idMappings, err = container.RemapIDMappings(remap); err != nil {
+			return nil, err
+		}
+	case len(uidMaps) > 0 || len(gidMaps) > 0:
+		idMappings = &container.IDMappings{}
+		for _, s := range uidMaps {
+			m, err := container.ParseIDMap(s)
+			if err != nil {
+				return nil, err
+			}
+			idMappings.UidMap = append(idMappings.UidMap, m)
+		}
+		for _, s := range gidMaps {
+			m, err := container.ParseIDMap(s)
+			if err != nil {
+				return nil, err
+			}
+			idMappings.GidMap = append(idMappings.GidMap, m)
+		}
+		// 只指定了一种映射时，另一种使用相同的映射
+		if len(idMappings.GidMap) == 0 {
+			idMappings.GidMap = idMappings.UidMap
+		}
+		if len(idMappings.UidMap) == 0 {
+			idMappings.UidMap = idMappings.GidMap
+		}
+	default:
+		return nil, nil
+	}
+	// 容器中的root必须映射到宿主机上的某个用户
+	if _, _, err := idMappings.RootPair(); err != nil {
+		return nil, err
+	}
+	return idMappings, nil
+}
@@ -0,0 +1,94 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"mydocker/pkg/devices"
+	"mydocker/pkg/seccomp"
+	"os"
+	"syscall"
+)
+
+// 通过管道传给容器init进程的完整配置
+type InitConfig struct {
+	Args     []string `json:"args"` // 用户进程的命令及参数，原样传递
+	Env      []string `json:"env"`
+	Cwd      string   `json:"cwd,omitempty"`  // 用户进程的工作目录，为空时不切换
+	User     string   `json:"user,omitempty"` // 以 name|uid[:name|gid] 的形式指定运行用户，为空时使用root
+	Hostname string   `json:"hostname,omitempty"`
+	// NIS域名，与主机名一样只在容器的UTS namespace中生效
+	Domainname string `json:"domainname,omitempty"`
+	// 宿主机上容器根文件系统的路径，init 的工作目录是它的上级目录
+	Rootfs string   `json:"rootfs"`
+	Mounts []*Mount `json:"mounts,omitempty"` // 按顺序执行的挂载，挂载点是容器内的路径
+	// 需要在容器 /dev 下创建的设备节点
+	Devices []*devices.Device `json:"devices,omitempty"`
+	// 用户进程的资源限制，没有指定的保持不变
+	Rlimits []*Rlimit `json:"rlimits,omitempty"`
+	// 用户进程保留的capabilities，为nil时不做修改
+	Capabilities []string `json:"capabilities"`
+	// 用户进程的seccomp配置，为nil时不限制系统调用
+	Seccomp *seccomp.Profile `json:"seccomp,omitempty"`
+	// 设置 no_new_privs，之后执行的程序不能再通过setuid或文件capabilities获得权限
+	NoNewPrivileges bool `json:"noNewPrivileges,omitempty"`
+	// 容器的根文件系统挂载为只读
+	ReadonlyRootfs bool     `json:"readonlyRootfs,omitempty"`
+	MaskedPaths    []string `json:"maskedPaths,omitempty"`   // 需要屏蔽的路径
+	ReadonlyPaths  []string `json:"readonlyPaths,omitempty"` // 需要挂载为只读的路径
+	// rootless模式下没有 fuse-overlayfs 时，由init在user namespace中把overlay挂载到rootfs上
+	RootfsMount *Mount `json:"rootfsMount,omitempty"`
+	// init 作为1号进程转发信号并回收僵尸进程，不直接替换成用户进程
+	Init bool `json:"init,omitempty"`
+	// ID映射由 newuidmap/newgidmap 在init启动后写入，init需要重新执行自己才能获得root的权限
+	Reexec bool `json:"reexec,omitempty"`
+}
+
+// 容器内的一个挂载点
+type Mount struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Device      string `json:"device"` // 文件系统类型，例如 proc、tmpfs
+	Flags       int    `json:"flags"`
+	Data        string `json:"data,omitempty"`
+}
+
+// 容器默认的挂载点，容器的user namespace中没有映射tty组(gid 5)时，devpts不指定属组
+func DefaultMounts(idMappings *IDMappings) []*Mount {
+	// MS_NOEXEC在本文件系统中不允许运行其他程序, MS_NODEV这个参数是自从Linux 2.4以来，所有mount的系统都会默认设定的参数。
+	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+	devptsData := "newinstance,ptmxmode=0666,mode=0620,gid=5"
+	if idMappings != nil {
+		if _, err := toHostID(5, idMappings.GidMap); err != nil {
+			devptsData = "newinstance,ptmxmode=0666,mode=0620"
+		}
+	}
+	return []*Mount{
+		{Source: "proc", Destination: "/proc", Device: "proc", Flags: defaultMountFlags},
+		{Source: "tmpfs", Destination: "/dev", Device: "tmpfs", Flags: syscall.MS_NOSUID | syscall.MS_STRICTATIME, Data: "mode=755,size=65536k"},
+		// newinstance 使容器拥有独立的伪终端编号，不会看到宿主机的 /dev/pts
+		{Source: "devpts", Destination: "/dev/pts", Device: "devpts", Flags: syscall.MS_NOSUID | syscall.MS_NOEXEC, Data: devptsData},
+		{Source: "shm", Destination: "/dev/shm", Device: "tmpfs", Flags: defaultMountFlags, Data: "mode=1777,size=65536k"},
+		{Source: "mqueue", Destination: "/dev/mqueue", Device: "mqueue", Flags: defaultMountFlags},
+		{Source: "sysfs", Destination: "/sys", Device: "sysfs", Flags: defaultMountFlags | syscall.MS_RDONLY},
+	}
+}
+
+// 把配置写入管道并关闭写端，init进程读到EOF后开始初始化
+func SendInitConfig(config *InitConfig, writePipe *os.File) error {
+	defer writePipe.Close()
+	if err := json.NewEncoder(writePipe).Encode(config); err != nil {
+		return fmt.Errorf("send init config error %v", err)
+	}
+	return nil
+}
+
+// init进程从fd 3读取父进程发来的配置
+func readInitConfig() (*InitConfig, error) {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	defer pipe.Close()
+	var config InitConfig
+	if err := json.NewDecoder(pipe).Decode(&config); err != nil {
+		return nil, fmt.Errorf("init read pipe error %v", err)
+	}
+	return &config, nil
+}
@@ -2,8 +2,10 @@ package container
 
 import (
 	"fmt"
+	"mydocker/pkg/cgroups/subsystems"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
@@ -11,14 +13,17 @@ import (
 
 var (
 	RUNNING             string = "running"
+	PAUSED              string = "paused"
 	STOP                string = "stopped"
 	EXIT                string = "exited"
 	DefaultInfoLocation string = "/var/run/mydocker/%s/"
 	ConfigName          string = "config.json"
 	ContainerLogFile    string = "container.log"
+	SeccompProfileName  string = "seccomp.json" // 容器使用的seccomp配置，exec 的进程使用同一个配置
 	RootUrl             string = "/root"
 	MntUrl              string = "/root/mnt/%s"
 	WriteLayerUrl       string = "/root/writeLayer/%s"
+	WorkUrl             string = "/root/work/%s" // overlay的工作目录，只在rootless模式下使用
 )
 
 type ContainerInfo struct {
@@ -30,33 +35,67 @@ type ContainerInfo struct {
 	Status      string   `json:"Status"`
 	Volume      string   `json:"volume"`      //容器的数据卷
 	PortMapping []string `json:"portmapping"` //端口映射
+	Hostname    string   `json:"hostname"`
+	Domainname  string   `json:"domainname,omitempty"`
+	// 容器的资源限制配置
+	ResourceConfig *subsystems.ResourceConfig `json:"resourceConfig"`
+	CgroupPath     string                     `json:"cgroupPath"` // 容器cgroup相对于各hierarchy根节点的路径
+	CgroupDriver   string                     `json:"cgroupDriver"`
+	IDMappings     *IDMappings                `json:"idMappings,omitempty"` // 容器user namespace的ID映射
+	Capabilities   []string                   `json:"capabilities"`         // 容器进程的effective capabilities
+	Privileged     bool                       `json:"privileged,omitempty"`
+	SecurityOpt    []string                   `json:"securityOpt,omitempty"`
+	ReadonlyRootfs bool                       `json:"readonlyRootfs,omitempty"`
+	User           string                     `json:"user,omitempty"`       // 容器进程的用户，也是 exec 的默认用户
+	WorkingDir     string                     `json:"workingDir,omitempty"` // 容器进程的工作目录，也是 exec 的默认工作目录
+	Ulimits        []*Rlimit                  `json:"ulimits,omitempty"`    // 容器进程的资源限制，exec 的进程也会使用
+	Init           bool                       `json:"init,omitempty"`       // 容器的1号进程是否是mydocker的init
+	Env            []string                   `json:"env,omitempty"`        // 容器进程的环境变量，也是 exec 的环境变量
+	// 容器的运行状态，由等待容器退出的进程记录
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	ExitCode   int    `json:"exitCode"`
+	OOMKilled  bool   `json:"oomKilled"`
 }
 
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, containerName, volume, imageName string, idMappings *IDMappings) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		log.Errorf("new pipe error %v", err)
 		return nil, nil
 	}
-	initCmd, err := os.Readlink("/proc/self/exe")
-	if err != nil {
-		log.Errorf("get init process error %v", err)
-		return nil, nil
-	}
-
-	// 自己调用自己，对创建的进程初始化
-	cmd := exec.Command(initCmd, "init")
+	// 自己调用自己，对创建的进程初始化。
+	// 直接执行 /proc/self/exe，user namespace 中的root可能没有权限访问可执行文件所在的路径
+	cmd := exec.Command("/proc/self/exe", "init")
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
+	// 使用user namespace时，容器中的root只是宿主机上的一个普通用户
+	if idMappings != nil {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		// 需要 newuidmap/newgidmap 时，映射在子进程启动后写入
+		if !idMappings.NeedIDMapHelper() {
+			cmd.SysProcAttr.UidMappings = toSysProcIDMaps(idMappings.UidMap)
+			cmd.SysProcAttr.GidMappings = toSysProcIDMaps(idMappings.GidMap)
+			// 子进程在宿主机上仍然是uid 0，没有映射到新的user namespace中，需要切换成namespace中的root
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+			if Rootless {
+				// 普通用户写入gid映射之前必须禁用 setgroups
+				cmd.SysProcAttr.Credential.NoSetGroups = true
+			} else {
+				// init 切换用户时需要调用 setgroups
+				cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+			}
+		}
+	}
 	if tty {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
 		dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(dirURL, 0622); err != nil {
+		if err := os.MkdirAll(dirURL, 0755); err != nil {
 			log.Errorf("NewParentProcess mkdir %s error %v", dirURL, err)
 			return nil, nil
 		}
@@ -72,12 +111,24 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 
 	// 注意，在这传入管道文件读取端的句柄
 	cmd.ExtraFiles = []*os.File{readPipe}
-	cmd.Env = append(os.Environ(), envSlice...)
-	NewWorkSpace(volume, imageName, containerName)
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
+	NewWorkSpace(volume, imageName, containerName, idMappings)
 	return cmd, writePipe
 }
 
+// 启动容器进程。user namespace 中的root没有权限访问宿主机上rootfs所在的路径（例如 /root），
+// 所以由当前进程切换到rootfs的上级目录，init 继承工作目录后只使用相对路径访问rootfs
+func StartParentProcess(cmd *exec.Cmd, containerName string) error {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(fmt.Sprintf(MntUrl, containerName))); err != nil {
+		return fmt.Errorf("chdir to rootfs error %v", err)
+	}
+	defer os.Chdir(pwd)
+	return cmd.Start()
+}
+
 func NewPipe() (*os.File, *os.File, error) {
 	read, write, err := os.Pipe()
 	if err != nil {
@@ -0,0 +1,58 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mydocker/pkg/devices"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// /dev 下默认创建的符号链接，依次为链接路径和链接目标
+var defaultDevSymlinks = [][2]string{
+	{"/dev/fd", "/proc/self/fd"},
+	{"/dev/stdin", "/proc/self/fd/0"},
+	{"/dev/stdout", "/proc/self/fd/1"},
+	{"/dev/stderr", "/proc/self/fd/2"},
+	// 使用容器自己的devpts实例分配伪终端
+	{"/dev/ptmx", "pts/ptmx"},
+}
+
+// 在容器的 /dev 中创建设备节点和符号链接，/dev 必须已经挂载为tmpfs
+func setupDev(rootfs string, devs []*devices.Device) error {
+	// user namespace 中没有权限 mknod，只能 bind mount 宿主机上的设备
+	bind := runningInUserNS()
+	for _, d := range devs {
+		// ptmx 在下面创建为指向 pts/ptmx 的链接
+		if d.Path == "/dev/ptmx" {
+			continue
+		}
+		createNode := devices.CreateDeviceNode
+		if bind {
+			createNode = devices.BindDeviceNode
+		}
+		if err := createNode(rootfs, d); err != nil {
+			return fmt.Errorf("create device %s error %v", d.Path, err)
+		}
+	}
+	for _, link := range defaultDevSymlinks {
+		dest := filepath.Join(rootfs, link[0])
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(link[1], dest); err != nil {
+			return fmt.Errorf("create symlink %s error %v", link[0], err)
+		}
+	}
+	return nil
+}
+
+// 当前进程是否运行在user namespace中，初始user namespace的映射覆盖了全部的uid
+func runningInUserNS() bool {
+	content, err := ioutil.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	return strings.Join(strings.Fields(string(content)), " ") != "0 0 4294967295"
+}
@@ -3,90 +3,271 @@ package container
 import (
 	"fmt"
 	"io/ioutil"
+	"mydocker/pkg/capabilities"
+	"mydocker/pkg/seccomp"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // 容器执行的第一个进程
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if len(cmdArray) == 0 {
-		return fmt.Errorf("run container get user command error, commandArray is nil")
-	}
-	// MS_NOEXEC在本文件系统中不允许运行其他程序, MS_NODEV这个参数是自从Linux 2.4以来，所有mount的系统都会默认设定的参数。
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	path, err := exec.LookPath(cmdArray[0])
+	config, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if config.Reexec {
+		return reexecInit(config)
+	}
+	if len(config.Args) == 0 {
+		return fmt.Errorf("run container get user command error, args is empty")
+	}
+	if err := setUpMount(config); err != nil {
+		return err
+	}
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			return fmt.Errorf("set hostname error %v", err)
+		}
+	}
+	if config.Domainname != "" {
+		if err := syscall.Setdomainname([]byte(config.Domainname)); err != nil {
+			return fmt.Errorf("set domainname error %v", err)
+		}
+	}
+	return execUserProcess(config)
+}
+
+// 在容器中执行的命令，由 exec 调用，这时已经进入了容器的namespace
+func RunContainerExecProcess() error {
+	config, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Args) == 0 {
+		return fmt.Errorf("exec container get user command error, args is empty")
+	}
+	// 没有记录环境变量的老容器使用1号进程的环境变量。容器进程不属于当前用户时（例如rootless模式下），
+	// 在宿主机上没有权限读取，进入容器的user namespace之后才能读取
+	if config.Env == nil {
+		env, err := readProcessEnv(1)
+		if err != nil {
+			return err
+		}
+		config.Env = env
+	}
+	return execUserProcess(config)
+}
+
+// 切换工作目录、用户和capabilities后执行用户进程，init 和 exec 共用
+func execUserProcess(config *InitConfig) error {
+	user, err := lookupUser(config.User)
+	if err != nil {
+		return err
+	}
+	// 没有设置HOME时使用用户的主目录
+	if !hasEnv(config.Env, "HOME") {
+		config.Env = append(config.Env, "HOME="+user.Home)
+	}
+	if config.Cwd != "" {
+		// 工作目录不存在时创建
+		if err := os.MkdirAll(config.Cwd, 0755); err != nil {
+			return fmt.Errorf("create workdir %s error %v", config.Cwd, err)
+		}
+		if err := syscall.Chdir(config.Cwd); err != nil {
+			return fmt.Errorf("chdir %s error %v", config.Cwd, err)
+		}
+	}
+	if err := setRlimits(config.Rlimits); err != nil {
+		return err
+	}
+	// capability 是线程的属性，从这里开始直到exec都要在同一个线程上
+	runtime.LockOSThread()
+	if config.Capabilities != nil {
+		if err := capabilities.DropBounding(config.Capabilities); err != nil {
+			return err
+		}
+		if err := capabilities.KeepCaps(); err != nil {
+			return fmt.Errorf("keep caps error %v", err)
+		}
+	}
+	if config.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("set no_new_privs error %v", err)
+		}
+	}
+	// 没有设置 no_new_privs 时，需要在切换用户和降低capabilities之前安装seccomp过滤器，
+	// 这时还拥有CAP_SYS_ADMIN，之后到exec之间用到的系统调用需要被配置允许
+	if config.Seccomp != nil && !config.NoNewPrivileges {
+		if err := installSeccomp(config); err != nil {
+			return err
+		}
+	}
+	if config.User != "" {
+		if err := setupUser(user); err != nil {
+			return err
+		}
+	}
+	if config.Capabilities != nil {
+		if err := capabilities.Apply(config.Capabilities); err != nil {
+			return err
+		}
+	}
+	// 查找用户命令时使用容器的环境变量中的PATH
+	os.Clearenv()
+	for _, env := range config.Env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	path, err := exec.LookPath(config.Args[0])
 	if err != nil {
 		log.Errorf("exec loop path error %v", err)
 		return err
 	}
 	log.Infof("find path %s", path)
+	// 设置了 no_new_privs 时在exec之前才安装seccomp过滤器
+	if config.Seccomp != nil && config.NoNewPrivileges {
+		if err := installSeccomp(config); err != nil {
+			return err
+		}
+	}
+	if config.Init {
+		return runInit(path, config)
+	}
 	// 系统调用实现了完成初始化动作并将用户进程运行起来的操作
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, config.Args, config.Env); err != nil {
 		log.Errorf(err.Error())
+		return err
 	}
 	return nil
 }
 
-func readUserCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
-	msg, err := ioutil.ReadAll(pipe)
+// 读取进程的环境变量，以 \0 分隔
+func readProcessEnv(pid int) ([]string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
 	if err != nil {
-		log.Errorf("init read pipe error %v", err)
-		return nil
+		return nil, fmt.Errorf("read environ of process %d error %v", pid, err)
+	}
+	var env []string
+	for _, kv := range strings.Split(string(content), "\x00") {
+		if kv != "" {
+			env = append(env, kv)
+		}
+	}
+	return env, nil
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
 	}
-	return strings.Split(string(msg), " ")
+	return false
 }
 
-// init挂载点
-func setUpMount() {
-	pwd, err := os.Getwd()
+// 按进程最终的capabilities生成并安装seccomp过滤器
+func installSeccomp(config *InitConfig) error {
+	filter, err := seccomp.Compile(config.Seccomp, config.Capabilities)
 	if err != nil {
-		log.Errorf("get current location error %v", err)
-		return
+		return err
 	}
-	log.Infof("current location is %s", pwd)
-	pivotRoot(pwd)
+	return seccomp.Install(filter)
+}
 
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+// 在rootfs中按顺序挂载，挂载点是相对于容器根目录的路径
+func mountAll(rootfs string, mounts []*Mount) error {
+	for _, m := range mounts {
+		dest := filepath.Join(rootfs, m.Destination)
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("create mount point %s error %v", m.Destination, err)
+		}
+		if err := syscall.Mount(m.Source, dest, m.Device, uintptr(m.Flags), m.Data); err != nil {
+			return fmt.Errorf("mount %s to %s error %v", m.Source, m.Destination, err)
+		}
+	}
+	return nil
 }
 
-func pivotRoot(root string) error {
+// 进入容器的根文件系统，并挂载容器需要的文件系统和设备。
+// 当前工作目录是rootfs的上级目录，这里只使用相对路径，不需要有访问宿主机上rootfs完整路径的权限
+func setUpMount(config *InitConfig) error {
+	// 宿主机的 / 通常是shared的，新mount namespace中的挂载会传播回宿主机，先全部改为private
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make / private error %v", err)
+	}
+	log.Infof("rootfs is %s", config.Rootfs)
+	rootfs := filepath.Base(config.Rootfs)
+	if m := config.RootfsMount; m != nil {
+		if err := syscall.Mount(m.Source, rootfs, m.Device, uintptr(m.Flags), m.Data); err != nil {
+			return fmt.Errorf("mount rootfs error %v", err)
+		}
+	}
 	/**
-	  为了使当前root的老 root 和新 root 不在同一个文件系统下，我们把root重新mount了一次
-	  bind mount是把相同的内容换了一个挂载点的挂载方法
+	  从宿主机复制过来的挂载点在user namespace中是被锁定的，不能作为 pivot_root 的 new_root，
+	  所以把rootfs重新bind mount到自身，得到一个新的挂载点，再进入这个挂载点
 	*/
-	if err := syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+	if err := syscall.Mount(rootfs, rootfs, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
 		return fmt.Errorf("mount rootfs to itself error: %v", err)
 	}
-	// 创建 rootfs/.pivot_root 存储 old_root
-	pivotDir := filepath.Join(root, ".pivot_root")
-	if err := os.Mkdir(pivotDir, 0777); err != nil {
+	if err := syscall.Chdir(rootfs); err != nil {
+		return fmt.Errorf("chdir rootfs error %v", err)
+	}
+	// 在 pivot_root 之前完成挂载，这时还可以访问宿主机上的设备文件
+	if err := mountAll(".", config.Mounts); err != nil {
+		return err
+	}
+	if err := setupDev(".", config.Devices); err != nil {
+		return err
+	}
+	if err := pivotRoot(); err != nil {
+		return err
+	}
+	if err := maskPaths(config.MaskedPaths); err != nil {
+		return err
+	}
+	if err := readonlyPaths(config.ReadonlyPaths); err != nil {
 		return err
 	}
-	// pivot_root 到新的rootfs, 现在老的 old_root 是挂载在rootfs/.pivot_root
-	// 挂载点现在依然可以在mount命令中看到
-	if err := syscall.PivotRoot(root, pivotDir); err != nil {
+	// 最后再把根目录改为只读，之前的挂载可能需要在rootfs中创建挂载点，工作目录也要提前创建
+	if config.ReadonlyRootfs {
+		if config.Cwd != "" {
+			if err := os.MkdirAll(config.Cwd, 0755); err != nil {
+				return fmt.Errorf("create workdir %s error %v", config.Cwd, err)
+			}
+		}
+		return remountReadonly("/")
+	}
+	return nil
+}
+
+// pivot_root(".", ".") 之后老的根目录叠加挂载在新的根目录上，再把它卸载掉，这样不需要在rootfs中创建临时目录
+func pivotRoot() error {
+	oldRoot, err := syscall.Open("/", syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open old root error %v", err)
+	}
+	defer syscall.Close(oldRoot)
+	if err := syscall.PivotRoot(".", "."); err != nil {
 		return fmt.Errorf("pivot_root %v", err)
 	}
+	// 切换到老的根目录上，卸载的就是叠加在上面的老的根目录
+	if err := syscall.Fchdir(oldRoot); err != nil {
+		return fmt.Errorf("fchdir old root %v", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root %v", err)
+	}
 	// 修改当前的工作目录到根目录
 	if err := syscall.Chdir("/"); err != nil {
 		return fmt.Errorf("chdir / %v", err)
 	}
-
-	pivotDir = filepath.Join("/", ".pivot_root")
-	// umount rootfs/.pivot_root
-	if err := syscall.Unmount(pivotDir, syscall.MNT_DETACH); err != nil {
-		return fmt.Errorf("unmount pivot_root dir %v", err)
-	}
-	// 删除临时文件夹
-	return os.Remove(pivotDir)
+	return nil
 }
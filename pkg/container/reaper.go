@@ -0,0 +1,69 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// --init 时init一直作为容器的1号进程运行，与 tini 类似：创建用户进程并把收到的信号转发给它，
+// 回收托管给1号进程的孤儿进程，用户进程退出后以它的退出码退出
+func runInit(path string, config *InitConfig) error {
+	// 在创建用户进程之前开始接收信号，避免错过它很快退出时的SIGCHLD
+	signals := make(chan os.Signal, 64)
+	signal.Notify(signals)
+	attr := &syscall.SysProcAttr{}
+	// 有控制终端时用户进程作为前台进程组，终端产生的信号只发给它，不需要再转发
+	if _, err := unix.IoctlGetInt(0, unix.TIOCGPGRP); err == nil {
+		attr.Foreground = true
+		attr.Ctty = 0
+	}
+	child, err := syscall.ForkExec(path, config.Args, &syscall.ProcAttr{
+		Env:   config.Env,
+		Files: []uintptr{0, 1, 2},
+		Sys:   attr,
+	})
+	if err != nil {
+		return fmt.Errorf("start user process error %v", err)
+	}
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGCHLD:
+			if exitCode, exited := reapChildren(child); exited {
+				os.Exit(exitCode)
+			}
+		case syscall.SIGURG:
+			// Go运行时用于抢占调度的信号，不转发
+		default:
+			syscall.Kill(child, sig.(syscall.Signal))
+		}
+	}
+	return nil
+}
+
+// 回收所有已经退出的子进程，用户进程退出时返回它的退出码，被信号杀死时为128加信号值
+func reapChildren(child int) (int, bool) {
+	exitCode, exited := 0, false
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return exitCode, exited
+		}
+		if pid != child {
+			continue
+		}
+		exited = true
+		if status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		} else {
+			exitCode = status.ExitStatus()
+		}
+	}
+}
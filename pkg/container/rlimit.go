@@ -0,0 +1,107 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 进程的资源限制，Soft 和 Hard 为 RLIM_INFINITY 时表示不限制
+type Rlimit struct {
+	Name string `json:"name"` // 例如 nofile、nproc、core
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// --ulimit 中可以使用的名字，与 ulimit 命令和Docker相同
+var rlimitResources = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// 解析 name=soft[:hard] 形式的资源限制，省略hard时与soft相同，-1 表示不限制
+func ParseRlimit(s string) (*Rlimit, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid ulimit %s, should be <name>=<soft>[:<hard>]", s)
+	}
+	name := parts[0]
+	if _, ok := rlimitResources[name]; !ok {
+		return nil, fmt.Errorf("invalid ulimit %s, unknown type %s", s, name)
+	}
+	values := strings.Split(parts[1], ":")
+	if len(values) > 2 {
+		return nil, fmt.Errorf("invalid ulimit %s, should be <name>=<soft>[:<hard>]", s)
+	}
+	soft, err := parseRlimitValue(values[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ulimit %s", s)
+	}
+	hard := soft
+	if len(values) == 2 {
+		if hard, err = parseRlimitValue(values[1]); err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s", s)
+		}
+	}
+	if soft > hard {
+		return nil, fmt.Errorf("invalid ulimit %s, soft limit should not be larger than hard limit", s)
+	}
+	return &Rlimit{Name: name, Soft: soft, Hard: hard}, nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	if s == "-1" || s == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// 把 override 中的资源限制合并到 base 中，同名的以 override 为准
+func MergeRlimits(base, override []*Rlimit) []*Rlimit {
+	var rlimits []*Rlimit
+	for _, r := range base {
+		found := false
+		for _, o := range override {
+			if o.Name == r.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rlimits = append(rlimits, r)
+		}
+	}
+	return append(rlimits, override...)
+}
+
+// 在切换用户和降低capabilities之前设置，提高hard限制需要CAP_SYS_RESOURCE。
+// 使用 syscall.Setrlimit，Go运行时在exec时才不会把 nofile 恢复成启动时的值
+func setRlimits(rlimits []*Rlimit) error {
+	for _, r := range rlimits {
+		resource, ok := rlimitResources[r.Name]
+		if !ok {
+			return fmt.Errorf("unknown ulimit type %s", r.Name)
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: r.Soft, Max: r.Hard}); err != nil {
+			return fmt.Errorf("set ulimit %s=%d:%d error %v", r.Name, r.Soft, r.Hard, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package container
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRlimit(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Rlimit
+	}{
+		{"nofile=1024:4096", Rlimit{"nofile", 1024, 4096}},
+		{"core=0", Rlimit{"core", 0, 0}},
+		{"nproc=100:-1", Rlimit{"nproc", 100, unix.RLIM_INFINITY}},
+	}
+	for _, tt := range tests {
+		r, err := ParseRlimit(tt.s)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.s, err)
+		}
+		if *r != tt.want {
+			t.Fatalf("%s: got %+v, want %+v", tt.s, *r, tt.want)
+		}
+	}
+	for _, s := range []string{"nofile", "foo=1", "nofile=a", "nofile=2:1", "nofile=1:2:3"} {
+		if _, err := ParseRlimit(s); err == nil {
+			t.Fatalf("%s: expected error", s)
+		}
+	}
+}
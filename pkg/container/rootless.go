@@ -0,0 +1,170 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// 以普通用户运行时为rootless模式，容器总是运行在user namespace中，状态和镜像层都放在用户自己的目录下
+var Rootless = false
+
+// 切换到rootless模式的目录布局：状态放在 $XDG_RUNTIME_DIR/mydocker，镜像层放在 ~/.local/share/mydocker
+func SetupRootless() error {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/tmp/mydocker-%d", os.Getuid())
+	}
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("get home dir error %v", err)
+		}
+		dataDir = filepath.Join(home, ".local", "share")
+	}
+	Rootless = true
+	DefaultInfoLocation = filepath.Join(runtimeDir, "mydocker") + "/%s/"
+	RootUrl = filepath.Join(dataDir, "mydocker")
+	MntUrl = RootUrl + "/mnt/%s"
+	WriteLayerUrl = RootUrl + "/writeLayer/%s"
+	WorkUrl = RootUrl + "/work/%s"
+	return nil
+}
+
+// rootless模式下容器中的root映射为当前用户，其他ID依次映射到 /etc/subuid、/etc/subgid 中分配给当前用户的从属ID。
+// 没有安装 newuidmap/newgidmap 或者没有分配从属ID时，容器中只有root一个用户
+func RootlessIDMappings() (*IDMappings, error) {
+	uid, gid := os.Getuid(), os.Getgid()
+	idMappings := &IDMappings{
+		UidMap: []IDMap{{ContainerID: 0, HostID: uid, Size: 1}},
+		GidMap: []IDMap{{ContainerID: 0, HostID: gid, Size: 1}},
+	}
+	for _, helper := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(helper); err != nil {
+			log.Warnf("%s not found, only root is mapped in the container", helper)
+			return idMappings, nil
+		}
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, fmt.Errorf("lookup user %d error %v", uid, err)
+	}
+	subUids, err := readSubIDs("/etc/subuid", u.Username, u.Uid)
+	if err != nil {
+		log.Warnf("%v, only root is mapped in the container", err)
+		return idMappings, nil
+	}
+	// 与 newgidmap 一样，/etc/subgid 也按用户名和uid查找，而不是组
+	subGids, err := readSubIDs("/etc/subgid", u.Username, u.Uid)
+	if err != nil {
+		log.Warnf("%v, only root is mapped in the container", err)
+		return idMappings, nil
+	}
+	for _, m := range subUids {
+		idMappings.UidMap = append(idMappings.UidMap, IDMap{ContainerID: m.ContainerID + 1, HostID: m.HostID, Size: m.Size})
+	}
+	for _, m := range subGids {
+		idMappings.GidMap = append(idMappings.GidMap, IDMap{ContainerID: m.ContainerID + 1, HostID: m.HostID, Size: m.Size})
+	}
+	return idMappings, nil
+}
+
+// 普通用户只能直接写入自己一个ID的映射，映射从属ID需要通过setuid的 newuidmap/newgidmap
+func (m *IDMappings) NeedIDMapHelper() bool {
+	return Rootless && (len(m.UidMap) > 1 || len(m.GidMap) > 1)
+}
+
+// 通过 newuidmap/newgidmap 为子进程写入ID映射
+func WriteIDMappings(pid int, m *IDMappings) error {
+	if err := runIDMapHelper("newuidmap", pid, m.UidMap); err != nil {
+		return err
+	}
+	return runIDMapHelper("newgidmap", pid, m.GidMap)
+}
+
+func runIDMapHelper(helper string, pid int, maps []IDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	if output, err := exec.Command(helper, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s error %v: %s", helper, err, output)
+	}
+	return nil
+}
+
+// 子进程执行时还没有ID映射，没有获得user namespace中root的capabilities，映射写入之后需要重新执行自己。
+// 配置写入一个新的管道后仍然通过fd 3传给新的init，配置远小于管道的缓冲区，写入不会阻塞
+func reexecInit(config *InitConfig) error {
+	config.Reexec = false
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	if err := SendInitConfig(config, writePipe); err != nil {
+		return err
+	}
+	if readPipe.Fd() != 3 {
+		if err := unix.Dup3(int(readPipe.Fd()), 3, 0); err != nil {
+			return fmt.Errorf("dup config pipe error %v", err)
+		}
+	} else if _, err := unix.FcntlInt(3, unix.F_SETFD, 0); err != nil {
+		return fmt.Errorf("clear close-on-exec of config pipe error %v", err)
+	}
+	return syscall.Exec("/proc/self/exe", os.Args, os.Environ())
+}
+
+// overlay的挂载参数，rootless模式下镜像层作为lowerdir，容器的可写层作为upperdir
+func overlayOptions(containerName, imageName string) string {
+	return fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		imageLayerUrl(imageName, nil), fmt.Sprintf(WriteLayerUrl, containerName), fmt.Sprintf(WorkUrl, containerName))
+}
+
+// rootless模式下无法使用aufs，安装了 fuse-overlayfs 时由当前用户直接挂载，否则留给容器的init挂载原生的overlay
+func createRootlessMountPoint(containerName, imageName string) error {
+	workURL := fmt.Sprintf(WorkUrl, containerName)
+	if err := os.MkdirAll(workURL, 0700); err != nil {
+		log.Errorf("mkdir dir %s error. %v", workURL, err)
+		return err
+	}
+	fuseOverlayfs, err := exec.LookPath("fuse-overlayfs")
+	if err != nil {
+		return nil
+	}
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if output, err := exec.Command(fuseOverlayfs, "-o", overlayOptions(containerName, imageName), mntURL).CombinedOutput(); err != nil {
+		log.Errorf("fuse-overlayfs mount %s error %v: %s", mntURL, err, output)
+		return err
+	}
+	return nil
+}
+
+// 没有 fuse-overlayfs 时需要由init在容器的mount namespace中挂载的rootfs，
+// 非rootless模式或者已经由 fuse-overlayfs 挂载时返回nil
+func RootfsMount(containerName, imageName string) *Mount {
+	if !Rootless {
+		return nil
+	}
+	if _, err := exec.LookPath("fuse-overlayfs"); err == nil {
+		return nil
+	}
+	return &Mount{Source: "overlay", Device: "overlay", Data: overlayOptions(containerName, imageName)}
+}
+
+// 原生overlay挂载在容器的mount namespace中，随容器退出自动卸载，只需要卸载 fuse-overlayfs
+func deleteRootlessMountPoint(mntURL string) {
+	for _, fusermount := range []string{"fusermount3", "fusermount"} {
+		if _, err := exec.LookPath(fusermount); err == nil {
+			exec.Command(fusermount, "-u", "-z", mntURL).Run()
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// 默认屏蔽的路径，容器中读到的是空文件或者空目录
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/interrupts",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// 默认只读的路径
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// 目录挂载一个只读的空tmpfs，文件用 /dev/null 覆盖，不存在的路径直接跳过
+func maskPaths(paths []string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("stat masked path %s error %v", path, err)
+		}
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("mask path %s error %v", path, err)
+		}
+	}
+	return nil
+}
+
+// 把路径bind mount到自身，再重新挂载为只读
+func readonlyPaths(paths []string) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("stat readonly path %s error %v", path, err)
+		}
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind readonly path %s error %v", path, err)
+		}
+		if err := remountReadonly(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 重新挂载为只读。user namespace 中不能去掉从宿主机继承的挂载标志，需要带上原来的标志
+func remountReadonly(path string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fmt.Errorf("statfs %s error %v", path, err)
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	// statfs 返回的 ST_* 标志与挂载标志的对应关系
+	for stFlag, msFlag := range map[int64]uintptr{
+		1 << 1:  syscall.MS_NOSUID,
+		1 << 2:  syscall.MS_NODEV,
+		1 << 3:  syscall.MS_NOEXEC,
+		1 << 10: syscall.MS_NOATIME,
+		1 << 11: syscall.MS_NODIRATIME,
+		1 << 12: syscall.MS_RELATIME,
+	} {
+		if st.Flags&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+	if err := syscall.Mount("", path, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s readonly error %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 容器中的 /etc/passwd 和 /etc/group，在进入容器的根目录之后读取
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// 执行用户进程使用的用户
+type execUser struct {
+	Uid   int
+	Gid   int
+	Sgids []int // 附加组
+	Home  string
+}
+
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// 解析 name|uid[:name|gid] 形式的用户，用户和组都可以是容器中 /etc/passwd、/etc/group 里的名字，也可以是数字。
+// 没有指定组时使用用户的主组，并加入 /etc/group 中包含这个用户的附加组；数字uid不在 /etc/passwd 中时主组为0
+func lookupUser(spec string) (*execUser, error) {
+	passwd, err := readPasswd(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupPath)
+	if err != nil {
+		return nil, err
+	}
+	return resolveUser(spec, passwd, groups)
+}
+
+func resolveUser(spec string, passwd []passwdEntry, groups []groupEntry) (*execUser, error) {
+	userArg, groupArg := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		userArg, groupArg = spec[:i], spec[i+1:]
+	}
+	if userArg == "" {
+		userArg = "0"
+	}
+
+	u := &execUser{Home: "/"}
+	name := ""
+	uid, numericErr := strconv.Atoi(userArg)
+	var found *passwdEntry
+	for i := range passwd {
+		if passwd[i].name == userArg || numericErr == nil && passwd[i].uid == uid {
+			found = &passwd[i]
+			break
+		}
+	}
+	switch {
+	case found != nil:
+		u.Uid, u.Gid, u.Home, name = found.uid, found.gid, found.home, found.name
+	case numericErr == nil && uid >= 0:
+		u.Uid = uid
+	default:
+		return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userArg)
+	}
+
+	if groupArg != "" {
+		gid, err := strconv.Atoi(groupArg)
+		matched := false
+		for _, g := range groups {
+			if g.name == groupArg || err == nil && g.gid == gid {
+				u.Gid, matched = g.gid, true
+				break
+			}
+		}
+		if !matched {
+			if err != nil || gid < 0 {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupArg)
+			}
+			u.Gid = gid
+		}
+		return u, nil
+	}
+	// 只有在 /etc/passwd 中找到的用户才有附加组
+	if name != "" {
+		for _, g := range groups {
+			for _, member := range g.members {
+				if member == name {
+					u.Sgids = append(u.Sgids, g.gid)
+					break
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
+// 文件不存在时当作空文件
+func readPasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+	})
+	return entries, err
+}
+
+func readGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 3 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		g := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			g.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, g)
+	})
+	return entries, err
+}
+
+// 逐行解析以冒号分隔的文件，跳过空行和注释
+func readColonFile(path string, parse func([]string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parse(strings.Split(line, ":"))
+	}
+	return scanner.Err()
+}
+
+// 切换到指定的用户。setgroups 被禁止时（rootless模式下只映射了一个ID）只能不设置附加组
+func setupUser(u *execUser) error {
+	if len(u.Sgids) > 0 || !setgroupsDenied() {
+		if err := syscall.Setgroups(u.Sgids); err != nil {
+			return fmt.Errorf("setgroups error %v", err)
+		}
+	}
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return fmt.Errorf("setgid %d error %v", u.Gid, err)
+	}
+	if err := syscall.Setuid(u.Uid); err != nil {
+		return fmt.Errorf("setuid %d error %v", u.Uid, err)
+	}
+	return nil
+}
+
+func setgroupsDenied() bool {
+	content, err := ioutil.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(content)) == "deny"
+}
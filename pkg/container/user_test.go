@@ -0,0 +1,42 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	passwd := []passwdEntry{
+		{name: "root", uid: 0, gid: 0, home: "/root"},
+		{name: "www", uid: 33, gid: 33, home: "/var/www"},
+	}
+	groups := []groupEntry{
+		{name: "root", gid: 0},
+		{name: "www", gid: 33},
+		{name: "wheel", gid: 10, members: []string{"root", "www"}},
+	}
+	tests := []struct {
+		spec string
+		want execUser
+	}{
+		{"", execUser{Uid: 0, Gid: 0, Sgids: []int{10}, Home: "/root"}},
+		{"www", execUser{Uid: 33, Gid: 33, Sgids: []int{10}, Home: "/var/www"}},
+		{"33:wheel", execUser{Uid: 33, Gid: 10, Home: "/var/www"}},
+		{"1000", execUser{Uid: 1000, Gid: 0, Home: "/"}},
+		{"1000:1000", execUser{Uid: 1000, Gid: 1000, Home: "/"}},
+	}
+	for _, tt := range tests {
+		u, err := resolveUser(tt.spec, passwd, groups)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if !reflect.DeepEqual(*u, tt.want) {
+			t.Fatalf("%q: got %+v, want %+v", tt.spec, *u, tt.want)
+		}
+	}
+	for _, spec := range []string{"nobody", "www:nogroup"} {
+		if _, err := resolveUser(spec, passwd, groups); err == nil {
+			t.Fatalf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// user namespace 中的一段ID映射，容器中的 [ContainerID, ContainerID+Size) 对应宿主机上的 [HostID, HostID+Size)
+type IDMap struct {
+	ContainerID int `json:"containerID"`
+	HostID      int `json:"hostID"`
+	Size        int `json:"size"`
+}
+
+// 容器的uid和gid映射，为nil时容器不使用user namespace
+type IDMappings struct {
+	UidMap []IDMap `json:"uidMap"`
+	GidMap []IDMap `json:"gidMap"`
+}
+
+// 解析形如 0:100000:65536 的映射
+func ParseIDMap(s string) (IDMap, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return IDMap{}, fmt.Errorf("invalid id map %s, should be <container-id>:<host-id>:<size>", s)
+	}
+	var ids [3]int
+	for i, part := range parts {
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 {
+			return IDMap{}, fmt.Errorf("invalid id map %s", s)
+		}
+		ids[i] = id
+	}
+	if ids[2] == 0 {
+		return IDMap{}, fmt.Errorf("invalid id map %s, size should be positive", s)
+	}
+	return IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// 根据 /etc/subuid 和 /etc/subgid 中分配给用户的从属ID生成映射，name 的格式为 <user>[:<group>]
+func RemapIDMappings(name string) (*IDMappings, error) {
+	userName, groupName := name, name
+	if idx := strings.Index(name, ":"); idx >= 0 {
+		userName, groupName = name[:idx], name[idx+1:]
+	}
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, fmt.Errorf("lookup user %s error %v", userName, err)
+	}
+	g, err := user.LookupGroup(groupName)
+	if err != nil {
+		return nil, fmt.Errorf("lookup group %s error %v", groupName, err)
+	}
+	uidMap, err := readSubIDs("/etc/subuid", u.Username, u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gidMap, err := readSubIDs("/etc/subgid", g.Name, g.Gid)
+	if err != nil {
+		return nil, err
+	}
+	return &IDMappings{UidMap: uidMap, GidMap: gidMap}, nil
+}
+
+// 读取 /etc/subuid 格式的文件，每行为 <用户名或ID>:<起始ID>:<数量>，多段依次映射到容器中从0开始的ID
+func readSubIDs(file, name, id string) ([]IDMap, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var maps []IDMap
+	containerID := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(parts) != 3 || (parts[0] != name && parts[0] != id) {
+			continue
+		}
+		start, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry in %s: %s", file, scanner.Text())
+		}
+		size, err := strconv.Atoi(parts[2])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid entry in %s: %s", file, scanner.Text())
+		}
+		maps = append(maps, IDMap{ContainerID: containerID, HostID: start, Size: size})
+		containerID += size
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("no subordinate ids for %s in %s", name, file)
+	}
+	return maps, nil
+}
+
+// 容器中的root在宿主机上对应的uid和gid
+func (m *IDMappings) RootPair() (int, int, error) {
+	uid, err := toHostID(0, m.UidMap)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid, err := toHostID(0, m.GidMap)
+	if err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
+
+func toHostID(containerID int, maps []IDMap) (int, error) {
+	for _, m := range maps {
+		if containerID >= m.ContainerID && containerID < m.ContainerID+m.Size {
+			return m.HostID + containerID - m.ContainerID, nil
+		}
+	}
+	return -1, fmt.Errorf("container id %d is not mapped", containerID)
+}
+
+func toSysProcIDMaps(maps []IDMap) []syscall.SysProcIDMap {
+	var sysMaps []syscall.SysProcIDMap
+	for _, m := range maps {
+		sysMaps = append(sysMaps, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return sysMaps
+}
+
+// 按映射修改目录中所有文件的属主，使容器中看到的属主与原来一致
+func shiftOwnership(dir string, m *IDMappings) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, err := toHostID(int(st.Uid), m.UidMap)
+		if err != nil {
+			return fmt.Errorf("shift owner of %s error %v", path, err)
+		}
+		gid, err := toHostID(int(st.Gid), m.GidMap)
+		if err != nil {
+			return fmt.Errorf("shift group of %s error %v", path, err)
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+		// chown 会清除 setuid 和 setgid 位，需要恢复原来的权限
+		if info.Mode()&os.ModeSymlink == 0 && info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			return os.Chmod(path, info.Mode())
+		}
+		return nil
+	})
+}
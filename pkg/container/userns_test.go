@@ -0,0 +1,56 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSubIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "subuid")
+	content := "other:200000:65536\nmydocker:100000:65536\n1001:300000:1000\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	maps, err := readSubIDs(file, "mydocker", "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IDMap{{0, 100000, 65536}, {65536, 300000, 1000}}
+	if len(maps) != len(want) || maps[0] != want[0] || maps[1] != want[1] {
+		t.Fatalf("got %v, want %v", maps, want)
+	}
+	if _, err := readSubIDs(file, "nobody", "65534"); err == nil {
+		t.Fatal("expected error for user without subordinate ids")
+	}
+}
+
+func TestIDMappings(t *testing.T) {
+	m, err := ParseIDMap("0:100000:65536")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings := &IDMappings{UidMap: []IDMap{m}, GidMap: []IDMap{m}}
+	uid, gid, err := mappings.RootPair()
+	if err != nil || uid != 100000 || gid != 100000 {
+		t.Fatalf("got %d:%d %v, want 100000:100000", uid, gid, err)
+	}
+	if id, err := toHostID(1000, mappings.UidMap); err != nil || id != 101000 {
+		t.Fatalf("got %d %v, want 101000", id, err)
+	}
+	if _, err := toHostID(65536, mappings.UidMap); err == nil {
+		t.Fatal("expected error for unmapped id")
+	}
+	for _, s := range []string{"0:100000", "a:1:1", "0:1:0", "-1:1:1"} {
+		if _, err := ParseIDMap(s); err == nil {
+			t.Fatalf("expected error for %s", s)
+		}
+	}
+}
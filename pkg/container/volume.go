@@ -9,16 +9,16 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+func NewWorkSpace(volume, imageName, containerName string, idMappings *IDMappings) {
+	CreateReadOnlyLayer(imageName, idMappings)
+	CreateWriteLayer(containerName, idMappings)
+	CreateMountPoint(containerName, imageName, idMappings)
 	// 根据 volume 判断是否执行挂载数据卷操作
 	if volume != "" {
 		volumeURLs := volumeUrlExtract(volume)
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(volumeURLs, containerName)
+			MountVolume(volumeURLs, containerName, idMappings)
 			log.Infof("%q", volumeURLs)
 		} else {
 			log.Infof("Volume parameter input is not correct.")
@@ -31,12 +31,16 @@ func volumeUrlExtract(volume string) (volumeURLs []string) {
 	return
 }
 
-func MountVolume(volumeURLs []string, containerName string) error {
+func MountVolume(volumeURLs []string, containerName string, idMappings *IDMappings) error {
 	// 创建宿主机文件目录
 	parentUrl := volumeURLs[0]
 	if err := os.Mkdir(parentUrl, 0777); err != nil {
 		log.Infof("Mkdir container dir %s error. %v", parentUrl, err)
 	}
+	// 数据卷属于容器中的root，对应到宿主机上是映射后的普通用户
+	if err := chownToRoot(parentUrl, idMappings); err != nil {
+		log.Errorf("Chown volume %s error %v", parentUrl, err)
+	}
 	// 在容器文件系统里创建挂载点
 	containerUrl := volumeURLs[1]
 	mntURL := fmt.Sprintf(MntUrl, containerName)
@@ -55,8 +59,8 @@ func MountVolume(volumeURLs []string, containerName string) error {
 }
 
 // 将 busybox.tar 解压到 busybox 目录下，作为容器的只读层
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
+func CreateReadOnlyLayer(imageName string, idMappings *IDMappings) error {
+	unTarFolderUrl := imageLayerUrl(imageName, idMappings) + "/"
 	imageUrl := RootUrl + "/" + imageName + ".tar"
 	exist, err := PathExists(unTarFolderUrl)
 	if err != nil {
@@ -72,27 +76,62 @@ func CreateReadOnlyLayer(imageName string) error {
 			log.Errorf("untar dir %s error %v", unTarFolderUrl, err)
 			return err
 		}
+		// rootless模式下解压出的文件属于当前用户，也就是容器中的root，不需要也没有权限修改属主
+		if idMappings != nil && !Rootless {
+			if err := shiftOwnership(unTarFolderUrl, idMappings); err != nil {
+				log.Errorf("shift ownership of %s error %v", unTarFolderUrl, err)
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// 镜像解压后的只读层目录，使用user namespace的容器需要一份按映射修改过属主的只读层，
+// 以容器中root对应的宿主机uid和gid区分，例如 /root/busybox-100000.100000
+func imageLayerUrl(imageName string, idMappings *IDMappings) string {
+	if idMappings == nil || Rootless {
+		return RootUrl + "/" + imageName
+	}
+	uid, gid, _ := idMappings.RootPair()
+	return fmt.Sprintf("%s/%s-%d.%d", RootUrl, imageName, uid, gid)
+}
+
+// 把目录的属主改为容器中root在宿主机上对应的用户
+func chownToRoot(path string, idMappings *IDMappings) error {
+	if idMappings == nil {
+		return nil
+	}
+	uid, gid, err := idMappings.RootPair()
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
 // 创建了一个名为 writeLayer 的文件夹作为容器唯一的可写层
-func CreateWriteLayer(containerName string) {
+func CreateWriteLayer(containerName string, idMappings *IDMappings) {
 	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.Mkdir(writeURL, 0777); err != nil {
+	if err := os.MkdirAll(writeURL, 0777); err != nil {
 		log.Errorf("mkdir dir %s error. %v", writeURL, err)
 	}
+	if err := chownToRoot(writeURL, idMappings); err != nil {
+		log.Errorf("chown dir %s error. %v", writeURL, err)
+	}
 }
 
 // 创建挂载点
-func CreateMountPoint(containerName, imageName string) error {
+func CreateMountPoint(containerName, imageName string, idMappings *IDMappings) error {
 	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	if err := os.Mkdir(mntUrl, 0777); err != nil {
+	if err := os.MkdirAll(mntUrl, 0777); err != nil {
 		log.Errorf("mkdir dir %s error. %v", mntUrl, err)
 	}
+	if Rootless {
+		return createRootlessMountPoint(containerName, imageName)
+	}
 	// 把 writeLayer 目录和 busybox 目录 mount 到 mnt 下
 	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
+	tmpImageLocation := imageLayerUrl(imageName, idMappings)
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
 	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput()
@@ -129,8 +168,9 @@ func DeleteWorkSpace(volume, containerName string) {
 
 func DeleteMountPoint(containerName string) error {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
-	_, err := exec.Command("umount", mntURL).CombinedOutput()
-	if err != nil {
+	if Rootless {
+		deleteRootlessMountPoint(mntURL)
+	} else if _, err := exec.Command("umount", mntURL).CombinedOutput(); err != nil {
 		log.Errorf("unmount %s error %v", mntURL, err)
 		return err
 	}
@@ -156,4 +196,10 @@ func DeleteWriteLayer(containerName string) {
 	if err := os.RemoveAll(writeURL); err != nil {
 		log.Infof("remove writeLayer dir %s error %v", writeURL, err)
 	}
+	if Rootless {
+		workURL := fmt.Sprintf(WorkUrl, containerName)
+		if err := os.RemoveAll(workURL); err != nil {
+			log.Infof("remove work dir %s error %v", workURL, err)
+		}
+	}
 }
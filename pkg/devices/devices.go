@@ -0,0 +1,204 @@
+package devices
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// major 或 minor 为 Wildcard 时表示匹配所有设备号
+	Wildcard = -1
+
+	CharDevice  = 'c'
+	BlockDevice = 'b'
+	// 只在cgroup规则中使用，表示所有类型的设备
+	AllDevices = 'a'
+)
+
+// 容器中的设备，既用于在容器 /dev 下创建设备节点，也用于生成cgroup的设备访问规则
+type Device struct {
+	Type        rune        `json:"type"`
+	Path        string      `json:"path"`               // 容器内的路径
+	HostPath    string      `json:"hostPath,omitempty"` // 宿主机上的路径，为空时与 Path 相同
+	Major       int64       `json:"major"`
+	Minor       int64       `json:"minor"`
+	Permissions string      `json:"permissions"` // r、w、m 的组合，分别表示读、写、创建设备节点
+	FileMode    os.FileMode `json:"fileMode"`
+	Uid         uint32      `json:"uid"`
+	Gid         uint32      `json:"gid"`
+}
+
+var (
+	// 默认在容器中创建并允许访问的设备
+	DefaultDevices = []*Device{
+		{Type: CharDevice, Path: "/dev/null", Major: 1, Minor: 3, Permissions: "rwm", FileMode: 0666},
+		{Type: CharDevice, Path: "/dev/zero", Major: 1, Minor: 5, Permissions: "rwm", FileMode: 0666},
+		{Type: CharDevice, Path: "/dev/full", Major: 1, Minor: 7, Permissions: "rwm", FileMode: 0666},
+		{Type: CharDevice, Path: "/dev/random", Major: 1, Minor: 8, Permissions: "rwm", FileMode: 0666},
+		{Type: CharDevice, Path: "/dev/urandom", Major: 1, Minor: 9, Permissions: "rwm", FileMode: 0666},
+		{Type: CharDevice, Path: "/dev/tty", Major: 5, Minor: 0, Permissions: "rwm", FileMode: 0666},
+		{Type: CharDevice, Path: "/dev/ptmx", Major: 5, Minor: 2, Permissions: "rwm", FileMode: 0666},
+	}
+
+	// 只允许访问、不需要创建节点的设备规则
+	DefaultAllowRules = []*Device{
+		// 允许在容器中 mknod 任意设备，但是否能读写仍受其他规则限制
+		{Type: CharDevice, Major: Wildcard, Minor: Wildcard, Permissions: "m"},
+		{Type: BlockDevice, Major: Wildcard, Minor: Wildcard, Permissions: "m"},
+		// /dev/pts/* 伪终端
+		{Type: CharDevice, Major: 136, Minor: Wildcard, Permissions: "rwm"},
+	}
+)
+
+// 容器默认的设备访问白名单
+func DefaultRules() []*Device {
+	return append(append([]*Device{}, DefaultAllowRules...), DefaultDevices...)
+}
+
+// 转换成cgroup v1 devices.allow 的格式，例如 "c 1:3 rwm"
+func (d *Device) CgroupString() string {
+	return fmt.Sprintf("%c %s:%s %s", d.Type, deviceNumberString(d.Major), deviceNumberString(d.Minor), d.Permissions)
+}
+
+func deviceNumberString(number int64) string {
+	if number == Wildcard {
+		return "*"
+	}
+	return strconv.FormatInt(number, 10)
+}
+
+// 根据宿主机上的设备文件生成设备信息，path 可以是指向设备的符号链接，例如 /dev/disk/by-uuid/xxx
+func DeviceFromPath(path, permissions string) (*Device, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return nil, fmt.Errorf("stat device %s error %v", path, err)
+	}
+	var devType rune
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		devType = CharDevice
+	case syscall.S_IFBLK:
+		devType = BlockDevice
+	default:
+		return nil, fmt.Errorf("%s is not a device", path)
+	}
+	return &Device{
+		Type:        devType,
+		Path:        path,
+		Major:       int64(major(st.Rdev)),
+		Minor:       int64(minor(st.Rdev)),
+		Permissions: permissions,
+		FileMode:    os.FileMode(st.Mode &^ syscall.S_IFMT),
+		Uid:         st.Uid,
+		Gid:         st.Gid,
+	}, nil
+}
+
+// 解析 --device 参数，格式为 <宿主机路径>[:<容器内路径>][:<权限>]，例如 /dev/sda:/dev/xvda:rwm
+func ParseDevice(device string) (*Device, error) {
+	parts := strings.Split(device, ":")
+	if len(parts) == 0 || len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device specification: %s", device)
+	}
+	hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 2:
+		if validPermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	}
+	if !validPermissions(permissions) {
+		return nil, fmt.Errorf("invalid device permissions %s", permissions)
+	}
+	if !filepath.IsAbs(containerPath) {
+		return nil, fmt.Errorf("device path %s in container should be absolute", containerPath)
+	}
+	d, err := DeviceFromPath(hostPath, permissions)
+	if err != nil {
+		return nil, err
+	}
+	d.HostPath = hostPath
+	d.Path = filepath.Clean(containerPath)
+	return d, nil
+}
+
+func validPermissions(permissions string) bool {
+	if permissions == "" {
+		return false
+	}
+	for _, c := range permissions {
+		if c != 'r' && c != 'w' && c != 'm' {
+			return false
+		}
+	}
+	return true
+}
+
+// 在 rootfs 中创建设备节点
+func CreateDeviceNode(rootfs string, d *Device) error {
+	dest := filepath.Join(rootfs, d.Path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	fileType := uint32(syscall.S_IFCHR)
+	if d.Type == BlockDevice {
+		fileType = syscall.S_IFBLK
+	}
+	// 已经存在的节点先删除，保证设备号是最新的
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syscall.Mknod(dest, fileType|uint32(d.FileMode), int(mkdev(d.Major, d.Minor))); err != nil {
+		return fmt.Errorf("mknod %s error %v", dest, err)
+	}
+	// mknod 受 umask 影响，需要再设置一次权限
+	if err := os.Chmod(dest, d.FileMode); err != nil {
+		return err
+	}
+	return os.Chown(dest, int(d.Uid), int(d.Gid))
+}
+
+// 在无法 mknod 的环境中（例如user namespace），把宿主机上的设备文件 bind mount 到 rootfs 中
+func BindDeviceNode(rootfs string, d *Device) error {
+	dest := filepath.Join(rootfs, d.Path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE, 0755)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	source := d.HostPath
+	if source == "" {
+		source = d.Path
+	}
+	if err := syscall.Mount(source, dest, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount device %s error %v", source, err)
+	}
+	return nil
+}
+
+// 从设备号中取出 major，与 glibc 的 gnu_dev_major 相同
+func major(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+}
+
+// 从设备号中取出 minor，与 glibc 的 gnu_dev_minor 相同
+func minor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) &^ 0xff)
+}
+
+// 由 major 和 minor 组合出设备号，与 glibc 的 gnu_dev_makedev 相同
+func mkdev(major, minor int64) uint64 {
+	ma, mi := uint64(major), uint64(minor)
+	return (ma&0xfff)<<8 | (ma&^0xfff)<<32 | (mi & 0xff) | (mi&^0xff)<<12
+}
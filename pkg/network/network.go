@@ -153,20 +153,20 @@ func (nw *Network) dump(dumpPath string) error {
 	// 打开保存的文件用于写入，模式参数为存在内容则清空、只写入、不存在则创建
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		log.Errorf("error：", err)
+		log.Errorf("error：%v", err)
 		return err
 	}
 	defer nwFile.Close()
 
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
-		log.Errorf("error：", err)
+		log.Errorf("error：%v", err)
 		return err
 	}
 
 	_, err = nwFile.Write(nwJson)
 	if err != nil {
-		log.Errorf("error：", err)
+		log.Errorf("error：%v", err)
 		return err
 	}
 	return nil
@@ -188,7 +188,7 @@ func (nw *Network) load(dumpPath string) error {
 
 	err = json.Unmarshal(nwJson[:n], nw)
 	if err != nil {
-		log.Errorf("Error load nw info", err)
+		log.Errorf("Error load nw info %v", err)
 		return err
 	}
 	return nil
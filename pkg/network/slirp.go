@@ -0,0 +1,45 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// rootless模式下无法创建veth和网桥，使用 slirp4netns 在用户态为容器提供网络。
+// 没有安装 slirp4netns 时返回错误，容器只有回环网络
+func StartSlirp(pid int) (*exec.Cmd, error) {
+	path, err := exec.LookPath("slirp4netns")
+	if err != nil {
+		return nil, err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+	// slirp4netns 在容器中创建好 tap0 并配置完地址和路由后，向 ready-fd 写入一个字节
+	cmd := exec.Command(path, "--configure", "--mtu=65520", "--disable-host-loopback", "--ready-fd=3", strconv.Itoa(pid), "tap0")
+	cmd.ExtraFiles = []*os.File{readyW}
+	if err := cmd.Start(); err != nil {
+		readyW.Close()
+		return nil, fmt.Errorf("start slirp4netns error %v", err)
+	}
+	readyW.Close()
+	buf := make([]byte, 1)
+	if n, _ := readyR.Read(buf); n == 0 {
+		cmd.Wait()
+		return nil, fmt.Errorf("slirp4netns exited before ready")
+	}
+	return cmd, nil
+}
+
+// 容器退出后结束 slirp4netns
+func StopSlirp(cmd *exec.Cmd) {
+	if cmd == nil {
+		return
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+}
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 容器网络的收发字节数
+type NetworkStats struct {
+	RxBytes uint64 `json:"rxBytes"`
+	TxBytes uint64 `json:"txBytes"`
+}
+
+// 统计容器 Net Namespace 中除 lo 以外所有网卡的收发字节数
+// /proc/<pid>/net/dev 展示的就是该进程所在 Net Namespace 的网卡，不需要再进入容器的网络空间
+func GetNetworkStats(pid string) (*NetworkStats, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%s/net/dev", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := &NetworkStats{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 前两行是表头，之后每行格式为 "  eth0: rx_bytes rx_packets ... tx_bytes tx_packets ..."
+		line := scanner.Text()
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		if strings.TrimSpace(line[:idx]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(line[idx+1:])
+		if len(fields) < 9 {
+			continue
+		}
+		rx, _ := strconv.ParseUint(fields[0], 10, 64)
+		tx, _ := strconv.ParseUint(fields[8], 10, 64)
+		stats.RxBytes += rx
+		stats.TxBytes += tx
+	}
+	return stats, scanner.Err()
+}
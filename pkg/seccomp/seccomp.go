@@ -0,0 +1,400 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Docker/OCI 格式的seccomp配置
+type Profile struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"` // 只支持本机架构，其他架构的系统调用使用默认动作
+	ArchMap         []ArchMap  `json:"archMap,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+type ArchMap struct {
+	Arch      string   `json:"architecture"`
+	SubArches []string `json:"subArchitectures"`
+}
+
+// 一组系统调用的规则，Includes 和 Excludes 根据capabilities、架构和内核版本决定是否使用这条规则
+type Syscall struct {
+	Name     string   `json:"name,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes Filter   `json:"includes,omitempty"`
+	Excludes Filter   `json:"excludes,omitempty"`
+}
+
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"` // GOARCH 形式的架构名，例如 amd64
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// 系统调用参数的比较条件，SCMP_CMP_MASKED_EQ 时 Value 是掩码，ValueTwo 是比较的值
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo"`
+	Op       Operator `json:"op"`
+}
+
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActLog         Action = "SCMP_ACT_LOG"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+)
+
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// seccomp 过滤器的返回值
+const (
+	retKillThread  = 0x00000000
+	retKillProcess = 0x80000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+)
+
+// struct seccomp_data 中各字段的偏移，参数按小端存放
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+const (
+	bpfMaxInsns            = 4096
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+)
+
+// 从文件加载Docker/OCI格式的seccomp配置
+func LoadProfile(path string) (*Profile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile %s error %v", path, err)
+	}
+	var profile Profile
+	if err := json.Unmarshal(content, &profile); err != nil {
+		return nil, fmt.Errorf("parse seccomp profile %s error %v", path, err)
+	}
+	return &profile, nil
+}
+
+// 当前架构是否有系统调用表，没有时无法生成seccomp过滤器
+func Supported() bool {
+	return nativeArch != 0
+}
+
+// 根据配置和进程最终的capabilities生成BPF程序，依次匹配每条规则，都不匹配时使用默认动作
+func Compile(profile *Profile, caps []string) ([]unix.SockFilter, error) {
+	if !Supported() {
+		return nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+	defaultRet, err := actionValue(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nativeArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, defaultRet),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+		jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, defaultRet),
+	}
+	kernel := kernelVersion()
+	// 累加器中是否还是系统调用号，比较参数之后需要重新加载
+	loaded := true
+	for _, call := range profile.Syscalls {
+		if !call.enabled(caps, kernel) {
+			continue
+		}
+		ret, err := actionValue(call.Action, call.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		// 与默认动作相同的规则不需要生成
+		if ret == defaultRet {
+			continue
+		}
+		names := append([]string(nil), call.Names...)
+		if call.Name != "" {
+			names = append(names, call.Name)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				// 当前架构没有的系统调用直接忽略
+				continue
+			}
+			b, err := syscallBlock(nr, call.Args, ret, loaded)
+			if err != nil {
+				return nil, fmt.Errorf("syscall %s: %v", name, err)
+			}
+			prog = append(prog, b...)
+			loaded = len(call.Args) == 0
+		}
+	}
+	prog = append(prog, stmt(unix.BPF_RET|unix.BPF_K, defaultRet))
+	if len(prog) > bpfMaxInsns {
+		return nil, fmt.Errorf("seccomp filter is too large, %d instructions", len(prog))
+	}
+	return prog, nil
+}
+
+// 安装seccomp过滤器，TSYNC 使进程的所有线程都使用同一个过滤器。
+// 没有设置 no_new_privs 时调用者需要拥有CAP_SYS_ADMIN
+func Install(filter []unix.SockFilter) error {
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.RawSyscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("install seccomp filter error %v", errno)
+	}
+	return nil
+}
+
+func actionValue(action Action, errnoRet *uint) (uint32, error) {
+	errno := uint32(unix.EPERM)
+	if errnoRet != nil {
+		errno = uint32(*errnoRet)
+	}
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		return retErrno | errno&0xffff, nil
+	case ActTrace:
+		return retTrace | errno&0xffff, nil
+	case ActLog:
+		return retLog, nil
+	case ActAllow:
+		return retAllow, nil
+	}
+	return 0, fmt.Errorf("unknown seccomp action %s", action)
+}
+
+// 规则要求的capabilities都存在、没有排除的capability、架构和内核版本符合时才生效
+func (s *Syscall) enabled(caps []string, kernel [2]int) bool {
+	for _, c := range s.Excludes.Caps {
+		if contains(caps, c) {
+			return false
+		}
+	}
+	for _, c := range s.Includes.Caps {
+		if !contains(caps, c) {
+			return false
+		}
+	}
+	if len(s.Excludes.Arches) > 0 && contains(s.Excludes.Arches, runtime.GOARCH) {
+		return false
+	}
+	if len(s.Includes.Arches) > 0 && !contains(s.Includes.Arches, runtime.GOARCH) {
+		return false
+	}
+	if s.Excludes.MinKernel != "" && !kernelBefore(kernel, s.Excludes.MinKernel) {
+		return false
+	}
+	if s.Includes.MinKernel != "" && kernelBefore(kernel, s.Includes.MinKernel) {
+		return false
+	}
+	return true
+}
+
+// 一个系统调用的规则：调用号和所有参数条件都满足时返回 ret，否则跳到下一条规则
+func syscallBlock(nr uint32, args []*Arg, ret uint32, loaded bool) ([]unix.SockFilter, error) {
+	b := &block{}
+	if !loaded {
+		b.add(stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr))
+	}
+	b.addFail(jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 0), false)
+	for _, arg := range args {
+		if err := b.addArg(arg); err != nil {
+			return nil, err
+		}
+	}
+	b.add(stmt(unix.BPF_RET|unix.BPF_K, ret))
+	return b.resolve()
+}
+
+// 生成中的一段BPF指令，不满足条件的跳转都指向这一段的末尾
+type block struct {
+	insns []unix.SockFilter
+	// 需要修正为跳到末尾的指令，true 表示修正jt，false 表示修正jf
+	fails map[int]bool
+}
+
+func (b *block) add(insns ...unix.SockFilter) {
+	b.insns = append(b.insns, insns...)
+}
+
+func (b *block) addFail(insn unix.SockFilter, onTrue bool) {
+	if b.fails == nil {
+		b.fails = map[int]bool{}
+	}
+	b.fails[len(b.insns)] = onTrue
+	b.insns = append(b.insns, insn)
+}
+
+func (b *block) resolve() ([]unix.SockFilter, error) {
+	for i, onTrue := range b.fails {
+		offset := len(b.insns) - i - 1
+		if offset > 255 {
+			return nil, fmt.Errorf("seccomp rule is too large")
+		}
+		if onTrue {
+			b.insns[i].Jt = uint8(offset)
+		} else {
+			b.insns[i].Jf = uint8(offset)
+		}
+	}
+	return b.insns, nil
+}
+
+// 64位参数分成高低两个32位比较
+func (b *block) addArg(arg *Arg) error {
+	if arg.Index > 5 {
+		return fmt.Errorf("invalid argument index %d", arg.Index)
+	}
+	lo := uint32(offsetArgs + 8*arg.Index)
+	hi := lo + 4
+	value := arg.Value
+	if arg.Op == OpMaskedEqual {
+		value = arg.ValueTwo
+	}
+	vlo, vhi := uint32(value), uint32(value>>32)
+	load := func(offset uint32) unix.SockFilter {
+		return stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offset)
+	}
+	jeq, jgt, jge := uint16(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K), uint16(unix.BPF_JMP|unix.BPF_JGT|unix.BPF_K), uint16(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K)
+
+	switch arg.Op {
+	case OpEqualTo:
+		b.add(load(hi))
+		b.addFail(jump(jeq, vhi, 0, 0), false)
+		b.add(load(lo))
+		b.addFail(jump(jeq, vlo, 0, 0), false)
+	case OpNotEqual:
+		// 高32位不相等时直接满足条件
+		b.add(load(hi))
+		b.add(jump(jeq, vhi, 0, 2))
+		b.add(load(lo))
+		b.addFail(jump(jeq, vlo, 0, 0), true)
+	case OpMaskedEqual:
+		mlo, mhi := uint32(arg.Value), uint32(arg.Value>>32)
+		b.add(load(hi), stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, mhi))
+		b.addFail(jump(jeq, vhi, 0, 0), false)
+		b.add(load(lo), stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, mlo))
+		b.addFail(jump(jeq, vlo, 0, 0), false)
+	case OpGreaterThan, OpGreaterEqual:
+		last := jgt
+		if arg.Op == OpGreaterEqual {
+			last = jge
+		}
+		b.add(load(hi))
+		b.add(jump(jgt, vhi, 3, 0))
+		b.addFail(jump(jeq, vhi, 0, 0), false)
+		b.add(load(lo))
+		b.addFail(jump(last, vlo, 0, 0), false)
+	case OpLessThan, OpLessEqual:
+		// a < v 等价于 !(a >= v)，a <= v 等价于 !(a > v)
+		last := jge
+		if arg.Op == OpLessEqual {
+			last = jgt
+		}
+		b.add(load(hi))
+		b.addFail(jump(jgt, vhi, 0, 0), true)
+		b.add(jump(jeq, vhi, 0, 2))
+		b.add(load(lo))
+		b.addFail(jump(last, vlo, 0, 0), true)
+	default:
+		return fmt.Errorf("unknown seccomp operator %s", arg.Op)
+	}
+	return nil
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 当前内核的主次版本号
+func kernelVersion() [2]int {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return [2]int{}
+	}
+	release := string(uts.Release[:])
+	if i := strings.IndexByte(release, 0); i >= 0 {
+		release = release[:i]
+	}
+	return parseKernelVersion(release)
+}
+
+func parseKernelVersion(release string) [2]int {
+	var version [2]int
+	parts := strings.SplitN(release, ".", 3)
+	for i := 0; i < len(parts) && i < 2; i++ {
+		// 只取开头的数字，例如 "18-generic" 中的 18
+		end := 0
+		for end < len(parts[i]) && parts[i][end] >= '0' && parts[i][end] <= '9' {
+			end++
+		}
+		version[i], _ = strconv.Atoi(parts[i][:end])
+	}
+	return version
+}
+
+func kernelBefore(kernel [2]int, min string) bool {
+	v := parseKernelVersion(min)
+	return kernel[0] < v[0] || kernel[0] == v[0] && kernel[1] < v[1]
+}
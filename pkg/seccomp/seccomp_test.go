@@ -0,0 +1,127 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// 执行生成的BPF程序，只实现了过滤器用到的指令
+func run(t *testing.T, prog []unix.SockFilter, arch, nr uint32, args [6]uint64) uint32 {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[offsetNr:], nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], arg)
+	}
+	var a uint32
+	for pc := 0; pc < len(prog); pc++ {
+		insn := prog[pc]
+		switch insn.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			a = binary.LittleEndian.Uint32(data[insn.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			a &= insn.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			var ok bool
+			switch insn.Code &^ (unix.BPF_JMP | unix.BPF_K) {
+			case unix.BPF_JEQ:
+				ok = a == insn.K
+			case unix.BPF_JGT:
+				ok = a > insn.K
+			case unix.BPF_JGE:
+				ok = a >= insn.K
+			}
+			if ok {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return insn.K
+		default:
+			t.Fatalf("unexpected instruction %+v", insn)
+		}
+	}
+	t.Fatal("program does not return")
+	return 0
+}
+
+func TestCompileArgs(t *testing.T) {
+	const big = 0x100000005
+	profile := &Profile{
+		DefaultAction: ActAllow,
+		Syscalls: []*Syscall{
+			{Names: []string{"read"}, Action: ActErrno, Args: []*Arg{{Index: 1, Value: big, Op: OpEqualTo}}},
+			{Names: []string{"write"}, Action: ActErrno, Args: []*Arg{{Index: 0, Value: big, Op: OpNotEqual}}},
+			{Names: []string{"open"}, Action: ActErrno, Args: []*Arg{{Index: 2, Value: big, Op: OpGreaterThan}}},
+			{Names: []string{"close"}, Action: ActErrno, Args: []*Arg{{Index: 0, Value: big, Op: OpGreaterEqual}}},
+			{Names: []string{"stat"}, Action: ActErrno, Args: []*Arg{{Index: 0, Value: big, Op: OpLessThan}}},
+			{Names: []string{"fstat"}, Action: ActErrno, Args: []*Arg{{Index: 0, Value: big, Op: OpLessEqual}}},
+			{Names: []string{"lstat"}, Action: ActErrno, Args: []*Arg{{Index: 0, Value: 0xff0000000f, ValueTwo: 0x0100000005, Op: OpMaskedEqual}}},
+			{Names: []string{"poll"}, Action: ActKillProcess},
+		},
+	}
+	prog, err := Compile(profile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := uint32(retErrno | unix.EPERM)
+	tests := []struct {
+		name string
+		nr   uint32
+		args [6]uint64
+		want uint32
+	}{
+		{"read eq", 0, [6]uint64{0, big}, denied},
+		{"read low word only", 0, [6]uint64{0, 5}, retAllow},
+		{"write ne", 1, [6]uint64{5}, denied},
+		{"write equal", 1, [6]uint64{big}, retAllow},
+		{"open gt high word", 2, [6]uint64{0, 0, 0x200000000}, denied},
+		{"open equal", 2, [6]uint64{0, 0, big}, retAllow},
+		{"open gt low word", 2, [6]uint64{0, 0, big + 1}, denied},
+		{"close ge", 3, [6]uint64{big}, denied},
+		{"close lt", 3, [6]uint64{big - 1}, retAllow},
+		{"stat lt", 4, [6]uint64{5}, denied},
+		{"stat equal", 4, [6]uint64{big}, retAllow},
+		{"fstat le", 5, [6]uint64{big}, denied},
+		{"fstat gt", 5, [6]uint64{0x200000000}, retAllow},
+		{"lstat masked", 6, [6]uint64{0x01000000f5}, denied},
+		{"lstat masked mismatch", 6, [6]uint64{0x02000000f5}, retAllow},
+		{"poll", 7, [6]uint64{}, retKillProcess},
+		{"other", 8, [6]uint64{}, retAllow},
+	}
+	for _, tt := range tests {
+		if got := run(t, prog, nativeArch, tt.nr, tt.args); got != tt.want {
+			t.Errorf("%s: got %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+	if got := run(t, prog, 0x40000003, 0, [6]uint64{0, big}); got != retAllow {
+		t.Errorf("foreign arch: got %#x, want default action", got)
+	}
+}
+
+func TestDefaultProfile(t *testing.T) {
+	prog, err := Compile(DefaultProfile(), []string{"CAP_CHOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eperm := uint32(retErrno | unix.EPERM)
+	nr := func(name string) uint32 { return syscallNumbers[name] }
+	if got := run(t, prog, nativeArch, nr("read"), [6]uint64{}); got != retAllow {
+		t.Errorf("read: got %#x", got)
+	}
+	if got := run(t, prog, nativeArch, nr("mount"), [6]uint64{}); got != eperm {
+		t.Errorf("mount without CAP_SYS_ADMIN: got %#x", got)
+	}
+	if got := run(t, prog, nativeArch, nr("clone"), [6]uint64{unix.CLONE_NEWNS}); got != eperm {
+		t.Errorf("clone with CLONE_NEWNS: got %#x", got)
+	}
+	if got := run(t, prog, nativeArch, nr("clone"), [6]uint64{unix.CLONE_VM | unix.CLONE_THREAD}); got != retAllow {
+		t.Errorf("clone thread: got %#x", got)
+	}
+	if got := run(t, prog, nativeArch, nr("clone3"), [6]uint64{}); got != retErrno|uint32(unix.ENOSYS) {
+		t.Errorf("clone3: got %#x", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !amd64
+// +build !amd64
+
+package seccomp
+
+// 其他架构还没有系统调用表，加载seccomp配置时返回错误
+const nativeArch = 0
+
+const x32SyscallBit = 0
+
+var syscallNumbers = map[string]uint32{}
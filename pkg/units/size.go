@@ -0,0 +1,54 @@
+package units
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	KiB = 1024
+	MiB = 1024 * KiB
+	GiB = 1024 * MiB
+	TiB = 1024 * GiB
+	PiB = 1024 * TiB
+)
+
+// 形如 512m、1.5g、100kb、512Mi 的容量写法，单位不区分大小写，i 只能跟在单位后面
+var sizeRegexp = regexp.MustCompile(`^(\d+(\.\d+)?)(?:([kKmMgGtTpP])[iI]?)?[bB]?$`)
+
+var binaryMap = map[string]int64{
+	"k": KiB,
+	"m": MiB,
+	"g": GiB,
+	"t": TiB,
+	"p": PiB,
+}
+
+// 把人类可读的容量（按1024进制）转换成字节数，例如 512m => 536870912
+func RAMInBytes(size string) (int64, error) {
+	matches := sizeRegexp.FindStringSubmatch(strings.TrimSpace(size))
+	if len(matches) != 4 {
+		return -1, fmt.Errorf("invalid size: '%s'", size)
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return -1, err
+	}
+	if unit, ok := binaryMap[strings.ToLower(matches[3])]; ok {
+		value *= float64(unit)
+	}
+	return int64(value), nil
+}
+
+// 把字节数转换成人类可读的容量，例如 536870912 => 512MiB
+func BytesSize(size float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", size, units[i])
+}
@@ -0,0 +1,48 @@
+package units
+
+import "testing"
+
+func TestRAMInBytes(t *testing.T) {
+	cases := map[string]int64{
+		"32":    32,
+		"32b":   32,
+		"32k":   32 * KiB,
+		"32KB":  32 * KiB,
+		"512m":  512 * MiB,
+		"512Mi": 512 * MiB,
+		"1g":    GiB,
+		"1.5g":  GiB + 512*MiB,
+		"2T":    2 * TiB,
+	}
+	for size, expected := range cases {
+		got, err := RAMInBytes(size)
+		if err != nil {
+			t.Errorf("parse %s error %v", size, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("parse %s got %d, expected %d", size, got, expected)
+		}
+	}
+
+	for _, size := range []string{"", "-1", "abc", "1x", "1.g", "m", "32i", "32ib"} {
+		if _, err := RAMInBytes(size); err == nil {
+			t.Errorf("parse %s should fail", size)
+		}
+	}
+}
+
+func TestBytesSize(t *testing.T) {
+	cases := map[float64]string{
+		512:           "512B",
+		1536:          "1.5KiB",
+		512 * MiB:     "512MiB",
+		3.5 * GiB:     "3.5GiB",
+		float64(2048): "2KiB",
+	}
+	for size, expected := range cases {
+		if got := BytesSize(size); got != expected {
+			t.Errorf("format %v got %s, expected %s", size, got, expected)
+		}
+	}
+}